@@ -27,47 +27,289 @@
 package capture
 
 import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"strings"
 	"time"
 )
 
+// Version of the capture file format. Captures created before this field was
+// introduced have no schema version and should be treated as version 1.
+const SCHEMA_VERSION = 2
+
+// Information about the TLS connection used by the request.
+type TLSInfo struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipherSuite"`
+	ServerName  string `json:"serverName,omitempty"`
+	// Application protocol negotiated using ALPN.
+	NegotiatedProtocol string `json:"negotiatedProtocol,omitempty"`
+	// Certificate chain presented by the client, starting with the leaf.
+	ClientCertificates []CertificateInfo `json:"clientCertificates,omitempty"`
+	// If true, the client certificate was verified against the client CAs.
+	ClientVerified bool `json:"clientVerified,omitempty"`
+}
+
+// Credentials of the process connected to a Unix domain socket.
+type PeerCredentials struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+	PID int `json:"pid"`
+}
+
+// Information about a certificate.
+type CertificateInfo struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serialNumber"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	SHA256       string    `json:"sha256"`
+	SHA1         string    `json:"sha1"`
+}
+
 type CapturedRequest struct {
-	Host      string              `json:"host,omitempty"`
-	Remote    string              `json:"remote,omitempty"`
+	SchemaVersion int    `json:"schemaVersion"`
+	ID            string `json:"id"`
+	Host          string `json:"host,omitempty"`
+	Remote        string `json:"remote,omitempty"`
+	Local         string `json:"local,omitempty"`
+	// Credentials of the peer, if the request was received by a Unix domain socket.
+	Peer      *PeerCredentials    `json:"peer,omitempty"`
 	URL       string              `json:"url"`
 	Method    string              `json:"Method"`
+	Protocol  string              `json:"protocol,omitempty"`
+	TLS       *TLSInfo            `json:"tls,omitempty"`
 	Timestamp time.Time           `json:"timestamp"`
 	Headers   map[string][]string `json:"headers"`
-	Body      []byte              `json:"body,omitempty"`
+	// The :authority pseudo-header of HTTP/2 requests.
+	Authority string `json:"authority,omitempty"`
+	// Trailers sent after the body.
+	Trailers map[string][]string `json:"trailers,omitempty"`
+	// Transfer encodings, from outermost to innermost.
+	TransferEncoding []string `json:"transferEncoding,omitempty"`
+	// The declared Content-Length or -1 if it is unknown.
+	ContentLength int64 `json:"contentLength"`
+	// Number of body bytes read from the client.
+	BodySize int64 `json:"bodySize"`
+	// If true, the body was cut at the maximum request size.
+	Truncated bool `json:"truncated,omitempty"`
+	// Name of the sidecar file that holds the complete body, if any.
+	BodyFile string `json:"bodyFile,omitempty"`
+	// Identifier of the response rule that matched this request.
+	RuleID string `json:"ruleId,omitempty"`
+	// Name of the virtual host that matched this request, if any.
+	VirtualHost string `json:"virtualHost,omitempty"`
+	// Name of the server that received this request.
+	Listener string `json:"listener,omitempty"`
+	// Content encodings removed from the body.
+	ContentEncoding []string `json:"contentEncoding,omitempty"`
+	// The body as sent by the client, before decoding.
+	EncodedBody []byte `json:"encodedBody,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+	// Decoded form fields.
+	Form map[string][]string `json:"form,omitempty"`
+	// Files uploaded using a multipart form.
+	Files []FormFile `json:"files,omitempty"`
+	// Bytes read beyond the maximum request size but not drained yet.
+	pending []byte
+}
+
+/*
+Creates a new unique request identifier.
+*/
+func NewRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		// Fallback to the current time
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+/*
+Formats a certificate fingerprint as colon separated uppercase hex, the same
+format used by openssl.
+*/
+func FormatFingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+/*
+Creates a new CertificateInfo from the given certificate.
+*/
+func NewCertificateInfo(cert *x509.Certificate) CertificateInfo {
+	sum256 := sha256.Sum256(cert.Raw)
+	sum1 := sha1.Sum(cert.Raw)
+	return CertificateInfo{
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		SerialNumber: cert.SerialNumber.Text(16),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		SHA256:       FormatFingerprint(sum256[:]),
+		SHA1:         FormatFingerprint(sum1[:]),
+	}
+}
+
+/*
+Creates a new TLSInfo from the given connection state. Returns nil if state is
+nil.
+*/
+func NewTLSInfo(state *tls.ConnectionState) *TLSInfo {
+	if state == nil {
+		return nil
+	}
+	ret := &TLSInfo{
+		Version:            tls.VersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		ClientVerified:     len(state.VerifiedChains) > 0,
+	}
+	for _, cert := range state.PeerCertificates {
+		ret.ClientCertificates = append(ret.ClientCertificates, NewCertificateInfo(cert))
+	}
+	return ret
 }
 
 /*
 Creates a new CapturedRequest from the given request.
 */
 func NewFromRequest(request *http.Request, maxBody int64) (CapturedRequest, error) {
-	// Read the body
-	body, err := io.ReadAll(io.LimitReader(request.Body, maxBody))
+	// Read the body. One extra byte is read to detect the truncation.
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxBody+1))
 	if err != nil {
-		return CapturedRequest{}, nil
+		return CapturedRequest{}, err
+	}
+	bodySize := int64(len(body))
+	truncated := bodySize > maxBody
+	var pending []byte
+	if truncated {
+		pending = body[maxBody:]
+		body = body[:maxBody]
 	}
 	headers := make(map[string][]string)
 	for k, v := range request.Header {
 		headers[k] = v
 	}
-	return CapturedRequest{
-		Host:      request.Host,
-		Remote:    request.RemoteAddr,
-		URL:       request.URL.String(),
-		Method:    request.Method,
-		Timestamp: time.Now().UTC(),
-		Headers:   headers,
-		Body:      body,
-	}, nil
+	local := ""
+	if addr, ok := request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		local = addr.String()
+	}
+	authority := ""
+	if request.ProtoMajor == 2 {
+		authority = request.Host
+	}
+	ret := CapturedRequest{
+		SchemaVersion:    SCHEMA_VERSION,
+		ID:               NewRequestID(),
+		Host:             request.Host,
+		Remote:           request.RemoteAddr,
+		Local:            local,
+		URL:              request.URL.String(),
+		Method:           request.Method,
+		Protocol:         request.Proto,
+		TLS:              NewTLSInfo(request.TLS),
+		Timestamp:        time.Now().UTC(),
+		Headers:          headers,
+		Authority:        authority,
+		TransferEncoding: request.TransferEncoding,
+		ContentLength:    request.ContentLength,
+		BodySize:         bodySize,
+		Truncated:        truncated,
+		Body:             body,
+		pending:          pending,
+	}
+	if !truncated {
+		ret.SetTrailers(request.Trailer)
+	}
+	return ret, nil
+}
+
+/*
+Records the trailers of the request. The trailers are only available after the
+body is read completely. Trailers declared but not sent are ignored.
+*/
+func (r *CapturedRequest) SetTrailers(trailer http.Header) {
+	r.Trailers = nil
+	for k, v := range trailer {
+		if len(v) == 0 {
+			continue
+		}
+		if r.Trailers == nil {
+			r.Trailers = make(map[string][]string)
+		}
+		r.Trailers[k] = v
+	}
+}
+
+/*
+Discards the remaining of the request body. It must be called with the same
+body used to create this instance. The discarded bytes are added to BodySize.
+*/
+func (r *CapturedRequest) Drain(body io.Reader) error {
+	r.pending = nil
+	n, err := io.Copy(io.Discard, body)
+	r.BodySize += n
+	return err
+}
+
+/*
+Writes the complete request body into a sidecar file inside parentDir. It must
+be called with the same body used to create this instance. On success, BodyFile
+is set to the name of the sidecar file.
+*/
+func (r *CapturedRequest) SpoolTo(parentDir string, body io.Reader) error {
+	name := r.GetFileTitle() + ".body"
+	writer, err := os.Create(path.Join(parentDir, name))
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(r.Body); err != nil {
+		writer.Close()
+		return err
+	}
+	if _, err := writer.Write(r.pending); err != nil {
+		writer.Close()
+		return err
+	}
+	r.pending = nil
+	n, err := io.Copy(writer, body)
+	r.BodySize += n
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	r.BodyFile = name
+	return writer.Close()
+}
+
+/*
+Returns the path of the URL of this request.
+*/
+func (r *CapturedRequest) Path() string {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
 }
 
 /*
@@ -29,6 +29,14 @@ package capture
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
@@ -46,9 +54,16 @@ func TestNewFromRequest(t *testing.T) {
 
 	c, err := NewFromRequest(r, 10000)
 	assert.Nil(t, err)
+	assert.Equal(t, SCHEMA_VERSION, c.SchemaVersion)
+	assert.Len(t, c.ID, 32)
 	assert.Equal(t, "PUT", c.Method)
+	assert.Equal(t, "HTTP/1.1", c.Protocol)
+	assert.Nil(t, c.TLS)
 	assert.Equal(t, []string{"b"}, c.Headers["a"])
 	assert.Equal(t, []byte("12345"), c.Body)
+	assert.Equal(t, int64(5), c.ContentLength)
+	assert.Equal(t, int64(5), c.BodySize)
+	assert.False(t, c.Truncated)
 	assert.Greater(t, time.Millisecond, time.Since(c.Timestamp))
 
 	r = httptest.NewRequest("PUT", "http://host1/path1", bytes.NewReader([]byte("12345")))
@@ -59,7 +74,102 @@ func TestNewFromRequest(t *testing.T) {
 	assert.Equal(t, "PUT", c.Method)
 	assert.Equal(t, []string{"b"}, c.Headers["a"])
 	assert.Equal(t, []byte("12"), c.Body)
+	assert.Equal(t, int64(5), c.ContentLength)
+	assert.True(t, c.Truncated)
 	assert.Greater(t, time.Millisecond, time.Since(c.Timestamp))
+
+	r = httptest.NewRequest("PUT", "https://host1/path1", bytes.NewReader([]byte("12345")))
+	r.TransferEncoding = []string{"chunked"}
+	r.ContentLength = -1
+	c, err = NewFromRequest(r, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("12345"), c.Body)
+	assert.Equal(t, int64(-1), c.ContentLength)
+	assert.Equal(t, int64(5), c.BodySize)
+	assert.False(t, c.Truncated)
+	assert.Equal(t, []string{"chunked"}, c.TransferEncoding)
+	require.NotNil(t, c.TLS)
+	assert.Equal(t, "TLS 1.2", c.TLS.Version)
+	assert.Equal(t, "host1", c.TLS.ServerName)
+	assert.Equal(t, "", c.Authority)
+	assert.Nil(t, c.Trailers)
+
+	r = httptest.NewRequest("POST", "https://host2/path1", bytes.NewReader([]byte("12345")))
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+	r.Trailer = http.Header{"X-Checksum": {"abc"}, "X-Missing": nil}
+	c, err = NewFromRequest(r, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/2.0", c.Protocol)
+	assert.Equal(t, "host2", c.Authority)
+	assert.Equal(t, map[string][]string{"X-Checksum": {"abc"}}, c.Trailers)
+
+	// Trailers are only available after the body is read
+	r = httptest.NewRequest("POST", "https://host2/path1", bytes.NewReader([]byte("12345")))
+	r.Trailer = http.Header{"X-Checksum": {"abc"}}
+	c, err = NewFromRequest(r, 2)
+	assert.Nil(t, err)
+	assert.Nil(t, c.Trailers)
+}
+
+func TestCapturedRequest_SetTrailers(t *testing.T) {
+	var c CapturedRequest
+	c.SetTrailers(nil)
+	assert.Nil(t, c.Trailers)
+	c.SetTrailers(http.Header{"A": nil})
+	assert.Nil(t, c.Trailers)
+	c.SetTrailers(http.Header{"A": {"1"}, "B": {}})
+	assert.Equal(t, map[string][]string{"A": {"1"}}, c.Trailers)
+}
+
+func TestFormatFingerprint(t *testing.T) {
+	assert.Equal(t, "", FormatFingerprint(nil))
+	assert.Equal(t, "00:1A:FF", FormatFingerprint([]byte{0x00, 0x1a, 0xff}))
+}
+
+func TestNewTLSInfo(t *testing.T) {
+	assert.Nil(t, NewTLSInfo(nil))
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0x1234),
+		Subject:      pkix.Name{CommonName: "client", Organization: []string{"Bank"}},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+
+	info := NewTLSInfo(&tls.ConnectionState{
+		Version:          tls.VersionTLS13,
+		CipherSuite:      tls.TLS_AES_128_GCM_SHA256,
+		ServerName:       "host1",
+		PeerCertificates: []*x509.Certificate{cert},
+	})
+	require.NotNil(t, info)
+	assert.Equal(t, "TLS 1.3", info.Version)
+	assert.Equal(t, "TLS_AES_128_GCM_SHA256", info.CipherSuite)
+	assert.False(t, info.ClientVerified)
+	require.Len(t, info.ClientCertificates, 1)
+	c := info.ClientCertificates[0]
+	assert.Equal(t, "CN=client,O=Bank", c.Subject)
+	assert.Equal(t, "CN=client,O=Bank", c.Issuer)
+	assert.Equal(t, "1234", c.SerialNumber)
+	assert.Equal(t, notBefore, c.NotBefore)
+	assert.Equal(t, notBefore.Add(time.Hour), c.NotAfter)
+	assert.Len(t, c.SHA256, 32*3-1)
+	assert.Len(t, c.SHA1, 20*3-1)
+}
+
+func TestNewRequestID(t *testing.T) {
+	id1 := NewRequestID()
+	id2 := NewRequestID()
+	assert.Len(t, id1, 32)
+	assert.Len(t, id2, 32)
+	assert.NotEqual(t, id1, id2)
 }
 
 func TestCapturedRequest_GetFileTitle(t *testing.T) {
@@ -76,12 +186,13 @@ func TestCapturedRequest_Save(t *testing.T) {
 
 	c, err := NewFromRequest(r, 10000)
 	require.Nil(t, err)
+	c.ID = "id1"
 	c.Timestamp = time.UnixMilli(12344310923).UTC()
 
 	buff := bytes.NewBuffer(nil)
 	err = c.Save(buff)
 	assert.Nil(t, err)
-	assert.Equal(t, "{\n  \"host\": \"host1\",\n  \"remote\": \"192.0.2.1:1234\",\n  \"url\": \"http://host1/path1\",\n  \"Method\": \"PUT\",\n  \"timestamp\": \"1970-05-23T20:58:30.923Z\",\n  \"headers\": {\n   \"a\": [\n    \"b\"\n   ]\n  },\n  \"body\": \"MTIzNDU=\"\n }",
+	assert.Equal(t, "{\n  \"schemaVersion\": 2,\n  \"id\": \"id1\",\n  \"host\": \"host1\",\n  \"remote\": \"192.0.2.1:1234\",\n  \"url\": \"http://host1/path1\",\n  \"Method\": \"PUT\",\n  \"protocol\": \"HTTP/1.1\",\n  \"timestamp\": \"1970-05-23T20:58:30.923Z\",\n  \"headers\": {\n   \"a\": [\n    \"b\"\n   ]\n  },\n  \"contentLength\": 5,\n  \"bodySize\": 5,\n  \"body\": \"MTIzNDU=\"\n }",
 		buff.String())
 }
 
@@ -92,6 +203,7 @@ func TestCapturedRequest_SaveTo(t *testing.T) {
 
 	c, err := NewFromRequest(r, 10000)
 	require.Nil(t, err)
+	c.ID = "id1"
 	c.Timestamp = time.UnixMilli(12344310923).UTC()
 
 	root := os.TempDir()
@@ -100,6 +212,34 @@ func TestCapturedRequest_SaveTo(t *testing.T) {
 
 	actual, err := os.ReadFile(path.Join(root, c.GetFileTitle()))
 	assert.Nil(t, err)
-	assert.Equal(t, "{\n  \"host\": \"host1\",\n  \"remote\": \"192.0.2.1:1234\",\n  \"url\": \"http://host1/path1\",\n  \"Method\": \"PUT\",\n  \"timestamp\": \"1970-05-23T20:58:30.923Z\",\n  \"headers\": {\n   \"a\": [\n    \"b\"\n   ]\n  },\n  \"body\": \"MTIzNDU=\"\n }",
+	assert.Equal(t, "{\n  \"schemaVersion\": 2,\n  \"id\": \"id1\",\n  \"host\": \"host1\",\n  \"remote\": \"192.0.2.1:1234\",\n  \"url\": \"http://host1/path1\",\n  \"Method\": \"PUT\",\n  \"protocol\": \"HTTP/1.1\",\n  \"timestamp\": \"1970-05-23T20:58:30.923Z\",\n  \"headers\": {\n   \"a\": [\n    \"b\"\n   ]\n  },\n  \"contentLength\": 5,\n  \"bodySize\": 5,\n  \"body\": \"MTIzNDU=\"\n }",
 		string(actual))
 }
+
+func TestCapturedRequest_Drain(t *testing.T) {
+	r := httptest.NewRequest("PUT", "http://host1/path1", bytes.NewReader([]byte("1234567890")))
+
+	c, err := NewFromRequest(r, 4)
+	require.Nil(t, err)
+	assert.True(t, c.Truncated)
+	assert.Nil(t, c.Drain(r.Body))
+	assert.Equal(t, []byte("1234"), c.Body)
+	assert.Equal(t, int64(10), c.BodySize)
+	assert.Nil(t, c.pending)
+}
+
+func TestCapturedRequest_SpoolTo(t *testing.T) {
+	r := httptest.NewRequest("PUT", "http://host1/path1", bytes.NewReader([]byte("1234567890")))
+
+	c, err := NewFromRequest(r, 4)
+	require.Nil(t, err)
+	root := t.TempDir()
+	assert.Nil(t, c.SpoolTo(root, r.Body))
+	assert.Equal(t, c.GetFileTitle()+".body", c.BodyFile)
+	assert.Equal(t, []byte("1234"), c.Body)
+	assert.Equal(t, int64(10), c.BodySize)
+
+	actual, err := os.ReadFile(path.Join(root, c.BodyFile))
+	assert.Nil(t, err)
+	assert.Equal(t, "1234567890", string(actual))
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package capture
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+Creates a reader that decodes the given content encoding. It returns an error if
+the encoding is not supported.
+*/
+func newDecoder(encoding string, reader io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(reader)
+	case "deflate":
+		// Some clients send raw deflate streams instead of zlib ones.
+		buff := bytes.NewBuffer(nil)
+		r, err := zlib.NewReader(io.TeeReader(reader, buff))
+		if err != nil {
+			return flate.NewReader(io.MultiReader(buff, reader)), nil
+		}
+		return r, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding '%s'", encoding)
+	}
+}
+
+/*
+Returns the content encodings applied to the body in the order they were
+applied. The identity encoding is ignored.
+*/
+func (r *CapturedRequest) contentEncodings() []string {
+	var ret []string
+	for _, value := range r.Headers["Content-Encoding"] {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding != "" && encoding != "identity" {
+				ret = append(ret, encoding)
+			}
+		}
+	}
+	return ret
+}
+
+/*
+Decodes the body according to its Content-Encoding header. Only gzip and
+deflate are supported. The decoded body is limited to maxBody bytes; if it is
+larger, the body is truncated and Truncated is set.
+
+If the body was spooled into parentDir, the complete body is decoded. If
+keepEncoded is true, the original bytes are kept in EncodedBody.
+
+It does nothing if the body is not encoded. On error, the body is left unchanged.
+*/
+func (r *CapturedRequest) DecodeBody(parentDir string, maxBody int64, keepEncoded bool) error {
+	encodings := r.contentEncodings()
+	if len(encodings) == 0 {
+		return nil
+	}
+	source, err := r.openBody(parentDir)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	var reader io.Reader = source
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoder, err := newDecoder(encodings[i], reader)
+		if err != nil {
+			return err
+		}
+		defer decoder.Close()
+		reader = decoder
+	}
+	// Protect against decompression bombs
+	body, err := io.ReadAll(io.LimitReader(reader, maxBody+1))
+	if err != nil && !(r.Truncated && errors.Is(err, io.ErrUnexpectedEOF)) {
+		return err
+	}
+	if int64(len(body)) > maxBody {
+		body = body[:maxBody]
+		r.Truncated = true
+	}
+	if keepEncoded {
+		r.EncodedBody = r.Body
+	}
+	r.Body = body
+	r.ContentEncoding = encodings
+	return nil
+}
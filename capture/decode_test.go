@@ -0,0 +1,143 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package capture
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	buff := bytes.NewBuffer(nil)
+	var writer io.WriteCloser
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(buff)
+	case "deflate":
+		writer = zlib.NewWriter(buff)
+	case "raw":
+		w, err := flate.NewWriter(buff, flate.DefaultCompression)
+		require.Nil(t, err)
+		writer = w
+	}
+	_, err := writer.Write(data)
+	require.Nil(t, err)
+	require.Nil(t, writer.Close())
+	return buff.Bytes()
+}
+
+func newEncodedCapture(t *testing.T, encoding string, body []byte, maxBody int64) CapturedRequest {
+	r := httptest.NewRequest("POST", "http://host1/path1", bytes.NewReader(body))
+	r.Header.Set("Content-Encoding", encoding)
+	c, err := NewFromRequest(r, maxBody)
+	require.Nil(t, err)
+	return c
+}
+
+func TestCapturedRequest_DecodeBody(t *testing.T) {
+	plain := []byte("0123456789012345678901234567890123456789")
+
+	for _, encoding := range []string{"gzip", "deflate", "raw"} {
+		encoded := compress(t, encoding, plain)
+		header := encoding
+		if encoding == "raw" {
+			header = "deflate"
+		}
+		c := newEncodedCapture(t, header, encoded, 1000)
+		assert.Nil(t, c.DecodeBody("", 1000, false))
+		assert.Equal(t, plain, c.Body)
+		assert.Equal(t, []string{header}, c.ContentEncoding)
+		assert.Nil(t, c.EncodedBody)
+		assert.False(t, c.Truncated)
+	}
+
+	// Multiple encodings
+	encoded := compress(t, "gzip", compress(t, "deflate", plain))
+	c := newEncodedCapture(t, "deflate, Gzip", encoded, 1000)
+	assert.Nil(t, c.DecodeBody("", 1000, true))
+	assert.Equal(t, plain, c.Body)
+	assert.Equal(t, []string{"deflate", "gzip"}, c.ContentEncoding)
+	assert.Equal(t, encoded, c.EncodedBody)
+
+	// Identity
+	c = newEncodedCapture(t, "identity", plain, 1000)
+	assert.Nil(t, c.DecodeBody("", 1000, true))
+	assert.Equal(t, plain, c.Body)
+	assert.Nil(t, c.ContentEncoding)
+	assert.Nil(t, c.EncodedBody)
+
+	// Unsupported
+	c = newEncodedCapture(t, "br", plain, 1000)
+	assert.ErrorContains(t, c.DecodeBody("", 1000, true), "unsupported content encoding 'br'")
+	assert.Equal(t, plain, c.Body)
+	assert.Nil(t, c.ContentEncoding)
+
+	// Invalid
+	c = newEncodedCapture(t, "gzip", plain, 1000)
+	assert.NotNil(t, c.DecodeBody("", 1000, true))
+	assert.Equal(t, plain, c.Body)
+}
+
+func TestCapturedRequest_DecodeBodyBomb(t *testing.T) {
+	plain := make([]byte, 1024*1024)
+	encoded := compress(t, "gzip", plain)
+	require.Less(t, len(encoded), 10000)
+
+	c := newEncodedCapture(t, "gzip", encoded, 10000)
+	assert.Nil(t, c.DecodeBody("", 10000, false))
+	assert.Len(t, c.Body, 10000)
+	assert.True(t, c.Truncated)
+
+	// Truncated encoded body
+	c = newEncodedCapture(t, "gzip", compress(t, "gzip", []byte("0123456789")), 20)
+	assert.True(t, c.Truncated)
+	assert.Nil(t, c.DecodeBody("", 20, false))
+	assert.True(t, c.Truncated)
+}
+
+func TestCapturedRequest_DecodeBodySpooled(t *testing.T) {
+	plain := bytes.Repeat([]byte("0123456789"), 100)
+	encoded := compress(t, "gzip", plain)
+
+	r := httptest.NewRequest("POST", "http://host1/path1", bytes.NewReader(encoded))
+	r.Header.Set("Content-Encoding", "gzip")
+	c, err := NewFromRequest(r, 10)
+	require.Nil(t, err)
+	root := t.TempDir()
+	require.Nil(t, c.SpoolTo(root, r.Body))
+	assert.Nil(t, c.DecodeBody(root, 2000, false))
+	assert.Equal(t, plain, c.Body)
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package capture
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path"
+)
+
+// Information about a file uploaded using a multipart form.
+type FormFile struct {
+	// Name of the form field.
+	Field string `json:"field"`
+	// File name declared by the client.
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size"`
+	// SHA-256 of the file contents in hex.
+	SHA256 string `json:"sha256"`
+	// Name of the sidecar file with the contents, if it was saved.
+	File string `json:"file,omitempty"`
+}
+
+/*
+Returns the media type of the body or an empty string if it is not set or is
+invalid.
+*/
+func (r *CapturedRequest) mediaType() (string, map[string]string) {
+	values := r.Headers["Content-Type"]
+	if len(values) == 0 {
+		return "", nil
+	}
+	mediaType, params, err := mime.ParseMediaType(values[0])
+	if err != nil {
+		return "", nil
+	}
+	return mediaType, params
+}
+
+/*
+Returns a reader for the complete body. If the body was spooled and not decoded,
+it reads the sidecar file inside parentDir.
+*/
+func (r *CapturedRequest) openBody(parentDir string) (io.ReadCloser, error) {
+	if r.BodyFile != "" && parentDir != "" && len(r.ContentEncoding) == 0 {
+		return os.Open(path.Join(parentDir, r.BodyFile))
+	}
+	return io.NopCloser(bytes.NewReader(r.Body)), nil
+}
+
+/*
+Decodes the body if it is a multipart/form-data or an
+application/x-www-form-urlencoded body. The fields are stored in Form and the
+uploaded files are described in Files.
+
+If parentDir is not empty, the contents of the uploaded files are saved as
+sidecar files inside it. Otherwise only their descriptions are kept.
+
+On error, Form and Files hold the fields parsed before the error.
+*/
+func (r *CapturedRequest) ParseForm(parentDir string) error {
+	mediaType, params := r.mediaType()
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		return r.parseURLEncoded(parentDir)
+	case "multipart/form-data":
+		return r.parseMultipart(parentDir, params["boundary"])
+	default:
+		return nil
+	}
+}
+
+func (r *CapturedRequest) parseURLEncoded(parentDir string) error {
+	body, err := r.openBody(parentDir)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	form, err := url.ParseQuery(string(data))
+	if len(form) > 0 {
+		r.Form = form
+	}
+	return err
+}
+
+func (r *CapturedRequest) parseMultipart(parentDir string, boundary string) error {
+	if boundary == "" {
+		return fmt.Errorf("multipart boundary not set")
+	}
+	body, err := r.openBody(parentDir)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	reader := multipart.NewReader(body, boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(part)
+			if err != nil {
+				return err
+			}
+			if r.Form == nil {
+				r.Form = make(map[string][]string)
+			}
+			r.Form[part.FormName()] = append(r.Form[part.FormName()], string(value))
+		} else {
+			if err := r.addFormFile(parentDir, part); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (r *CapturedRequest) addFormFile(parentDir string, part *multipart.Part) error {
+	f := FormFile{
+		Field:       part.FormName(),
+		FileName:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+	}
+	hash := sha256.New()
+	var writer io.Writer = hash
+	if parentDir != "" {
+		f.File = fmt.Sprintf("%s.file%d", r.GetFileTitle(), len(r.Files))
+		file, err := os.Create(path.Join(parentDir, f.File))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = io.MultiWriter(hash, file)
+	}
+	size, err := io.Copy(writer, part)
+	if err != nil {
+		return err
+	}
+	f.Size = size
+	f.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	r.Files = append(r.Files, f)
+	return nil
+}
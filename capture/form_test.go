@@ -0,0 +1,134 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package capture
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Creates a multipart body with one field and one file.
+func newMultipartBody(t *testing.T) (string, []byte) {
+	buff := bytes.NewBuffer(nil)
+	writer := multipart.NewWriter(buff)
+	require.Nil(t, writer.WriteField("name", "value"))
+	fw, err := writer.CreateFormFile("doc", "../a.txt")
+	require.Nil(t, err)
+	_, err = fw.Write([]byte("file contents"))
+	require.Nil(t, err)
+	require.Nil(t, writer.Close())
+	return writer.FormDataContentType(), buff.Bytes()
+}
+
+func TestCapturedRequest_ParseFormURLEncoded(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://host1/path1", strings.NewReader("a=1&b=2&a=3"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	c, err := NewFromRequest(r, 10000)
+	require.Nil(t, err)
+	assert.Nil(t, c.ParseForm(""))
+	assert.Equal(t, map[string][]string{"a": {"1", "3"}, "b": {"2"}}, c.Form)
+	assert.Nil(t, c.Files)
+}
+
+func TestCapturedRequest_ParseFormMultipart(t *testing.T) {
+	contentType, body := newMultipartBody(t)
+	hash := sha256.Sum256([]byte("file contents"))
+
+	r := httptest.NewRequest("POST", "http://host1/path1", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	c, err := NewFromRequest(r, 10000)
+	require.Nil(t, err)
+	root := t.TempDir()
+	assert.Nil(t, c.ParseForm(root))
+	assert.Equal(t, map[string][]string{"name": {"value"}}, c.Form)
+	require.Len(t, c.Files, 1)
+	assert.Equal(t, "doc", c.Files[0].Field)
+	assert.Equal(t, "a.txt", c.Files[0].FileName)
+	assert.Equal(t, "application/octet-stream", c.Files[0].ContentType)
+	assert.Equal(t, int64(13), c.Files[0].Size)
+	assert.Equal(t, hex.EncodeToString(hash[:]), c.Files[0].SHA256)
+	assert.Equal(t, c.GetFileTitle()+".file0", c.Files[0].File)
+	actual, err := os.ReadFile(path.Join(root, c.Files[0].File))
+	assert.Nil(t, err)
+	assert.Equal(t, "file contents", string(actual))
+
+	// Without sidecars
+	r = httptest.NewRequest("POST", "http://host1/path1", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	c, err = NewFromRequest(r, 10000)
+	require.Nil(t, err)
+	assert.Nil(t, c.ParseForm(""))
+	require.Len(t, c.Files, 1)
+	assert.Equal(t, "", c.Files[0].File)
+	assert.Equal(t, hex.EncodeToString(hash[:]), c.Files[0].SHA256)
+
+	// From the spooled body
+	r = httptest.NewRequest("POST", "http://host1/path1", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	c, err = NewFromRequest(r, 10)
+	require.Nil(t, err)
+	require.Nil(t, c.SpoolTo(root, r.Body))
+	assert.Nil(t, c.ParseForm(root))
+	assert.Equal(t, map[string][]string{"name": {"value"}}, c.Form)
+	require.Len(t, c.Files, 1)
+	assert.Equal(t, int64(13), c.Files[0].Size)
+
+	// Truncated
+	r = httptest.NewRequest("POST", "http://host1/path1", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	c, err = NewFromRequest(r, int64(len(body)-20))
+	require.Nil(t, err)
+	assert.NotNil(t, c.ParseForm(""))
+	assert.Equal(t, map[string][]string{"name": {"value"}}, c.Form)
+}
+
+func TestCapturedRequest_ParseFormOther(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://host1/path1", strings.NewReader("a=1"))
+	r.Header.Set("Content-Type", "text/plain")
+	c, err := NewFromRequest(r, 10000)
+	require.Nil(t, err)
+	assert.Nil(t, c.ParseForm(""))
+	assert.Nil(t, c.Form)
+
+	r = httptest.NewRequest("POST", "http://host1/path1", strings.NewReader("a=1"))
+	r.Header.Set("Content-Type", "multipart/form-data")
+	c, err = NewFromRequest(r, 10000)
+	require.Nil(t, err)
+	assert.ErrorContains(t, c.ParseForm(""), "multipart boundary not set")
+}
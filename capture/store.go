@@ -0,0 +1,289 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package capture
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Returned when a captured request does not exist.
+var ErrNotFound = errors.New("captured request not found")
+
+// Pattern of the names of the capture files. See GetFileTitle().
+var captureFilePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{6}\.\d{9}\.[^.]+$`)
+
+// Criteria used to select captured requests. Empty fields match all requests.
+type Filter struct {
+	Method string
+	// Regular expression tested against the URL path.
+	PathPattern *regexp.Regexp
+	Host        string
+	RuleID      string
+	Listener    string
+	Since       time.Time
+	Until       time.Time
+	// Maximum number of requests returned. 0 means no limit.
+	Limit int
+}
+
+// Returns true if the given request matches this filter.
+func (f *Filter) Match(r *CapturedRequest) bool {
+	if f == nil {
+		return true
+	}
+	if f.Method != "" && f.Method != r.Method {
+		return false
+	}
+	if f.PathPattern != nil && !f.PathPattern.MatchString(r.Path()) {
+		return false
+	}
+	if f.Host != "" && f.Host != r.Host {
+		return false
+	}
+	if f.RuleID != "" && f.RuleID != r.RuleID {
+		return false
+	}
+	if f.Listener != "" && f.Listener != r.Listener {
+		return false
+	}
+	if !f.Since.IsZero() && r.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// This interface is implemented by all capture destinations.
+type Store interface {
+	// Saves a captured request.
+	Save(r *CapturedRequest) error
+	// Lists the captured requests that match the filter in chronological order.
+	// A nil filter matches all requests.
+	List(filter *Filter) ([]*CapturedRequest, error)
+	// Returns the captured request with the given ID or ErrNotFound.
+	Get(id string) (*CapturedRequest, error)
+	// Deletes the captured request with the given ID or returns ErrNotFound.
+	Delete(id string) error
+	// Deletes all captured requests.
+	Clear() error
+}
+
+//------------------------------------------------------------------------------
+
+// Store that saves the captured requests as files inside a directory. Other
+// files inside the directory are ignored.
+type DirStore struct {
+	Dir string
+}
+
+func (s *DirStore) Save(r *CapturedRequest) error {
+	return r.SaveTo(s.Dir)
+}
+
+// Loads all captures inside the directory in chronological order.
+func (s *DirStore) loadAll() ([]*CapturedRequest, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	// Names sort in chronological order
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	var ret []*CapturedRequest
+	for _, entry := range entries {
+		if entry.IsDir() || !captureFilePattern.MatchString(entry.Name()) {
+			continue
+		}
+		r, err := LoadFrom(path.Join(s.Dir, entry.Name()))
+		if err != nil {
+			// Not a capture or it is being written
+			continue
+		}
+		if r.ID == "" {
+			// Legacy captures are identified by their file titles
+			r.ID = entry.Name()
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+func (s *DirStore) List(filter *Filter) ([]*CapturedRequest, error) {
+	all, err := s.loadAll()
+	if err != nil {
+		return nil, err
+	}
+	return applyFilter(all, filter), nil
+}
+
+func (s *DirStore) Get(id string) (*CapturedRequest, error) {
+	all, err := s.loadAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range all {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Removes the capture file and all its sidecar files.
+func (s *DirStore) remove(r *CapturedRequest) error {
+	names := []string{r.GetFileTitle()}
+	if r.BodyFile != "" {
+		names = append(names, r.BodyFile)
+	}
+	for _, f := range r.Files {
+		if f.File != "" {
+			names = append(names, f.File)
+		}
+	}
+	for _, name := range names {
+		if err := os.Remove(path.Join(s.Dir, path.Base(name))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DirStore) Delete(id string) error {
+	r, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	return s.remove(r)
+}
+
+func (s *DirStore) Clear() error {
+	all, err := s.loadAll()
+	if err != nil {
+		return err
+	}
+	for _, r := range all {
+		if err := s.remove(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// Store that keeps the captured requests in memory. It is safe for concurrent
+// use.
+type MemoryStore struct {
+	mutex    sync.Mutex
+	requests []*CapturedRequest
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Save(r *CapturedRequest) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, r)
+	return nil
+}
+
+func (s *MemoryStore) List(filter *Filter) ([]*CapturedRequest, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return applyFilter(s.requests, filter), nil
+}
+
+func (s *MemoryStore) Get(id string) (*CapturedRequest, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, r := range s.requests {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, r := range s.requests {
+		if r.ID == id {
+			s.requests = append(s.requests[:i:i], s.requests[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryStore) Clear() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = nil
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// Returns the requests that match the filter, respecting its limit.
+func applyFilter(requests []*CapturedRequest, filter *Filter) []*CapturedRequest {
+	ret := make([]*CapturedRequest, 0)
+	for _, r := range requests {
+		if filter.Match(r) {
+			ret = append(ret, r)
+			if filter != nil && filter.Limit > 0 && len(ret) == filter.Limit {
+				break
+			}
+		}
+	}
+	return ret
+}
+
+/*
+Loads a captured request from a file.
+*/
+func LoadFrom(file string) (*CapturedRequest, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	r := new(CapturedRequest)
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package capture
+
+import (
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCapture(t *testing.T, method string, url string, ts time.Time) *CapturedRequest {
+	c, err := NewFromRequest(httptest.NewRequest(method, url, strings.NewReader("body")), 1000)
+	require.Nil(t, err)
+	c.Timestamp = ts
+	return &c
+}
+
+func TestFilter_Match(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := newTestCapture(t, "POST", "http://host1/a/b?x=1", ts)
+	c.RuleID = "r1"
+
+	var f *Filter
+	assert.True(t, f.Match(c))
+	assert.True(t, (&Filter{}).Match(c))
+	assert.True(t, (&Filter{Method: "POST"}).Match(c))
+	assert.False(t, (&Filter{Method: "GET"}).Match(c))
+	assert.True(t, (&Filter{PathPattern: regexp.MustCompile("^/a/b$")}).Match(c))
+	assert.False(t, (&Filter{PathPattern: regexp.MustCompile("^/a$")}).Match(c))
+	assert.True(t, (&Filter{Host: "host1"}).Match(c))
+	assert.False(t, (&Filter{Host: "host2"}).Match(c))
+	assert.True(t, (&Filter{RuleID: "r1"}).Match(c))
+	assert.False(t, (&Filter{RuleID: "r2"}).Match(c))
+	c.Listener = "l1"
+	assert.True(t, (&Filter{Listener: "l1"}).Match(c))
+	assert.False(t, (&Filter{Listener: "l2"}).Match(c))
+	assert.True(t, (&Filter{Since: ts, Until: ts}).Match(c))
+	assert.False(t, (&Filter{Since: ts.Add(time.Second)}).Match(c))
+	assert.False(t, (&Filter{Until: ts.Add(-time.Second)}).Match(c))
+}
+
+func TestDirStore(t *testing.T) {
+	root := t.TempDir()
+	s := &DirStore{Dir: root}
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	c1 := newTestCapture(t, "POST", "http://host1/a", ts)
+	c2 := newTestCapture(t, "GET", "http://host1/b", ts.Add(time.Second))
+	c2.BodyFile = c2.GetFileTitle() + ".body"
+	require.Nil(t, os.WriteFile(path.Join(root, c2.BodyFile), []byte("x"), 0644))
+	require.Nil(t, os.WriteFile(path.Join(root, "log.log"), []byte("x"), 0644))
+	require.Nil(t, s.Save(c2))
+	require.Nil(t, s.Save(c1))
+
+	list, err := s.List(nil)
+	require.Nil(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, c1.ID, list[0].ID)
+	assert.Equal(t, c2.ID, list[1].ID)
+
+	list, err = s.List(&Filter{Method: "GET"})
+	require.Nil(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, c2.ID, list[0].ID)
+
+	list, err = s.List(&Filter{Limit: 1})
+	require.Nil(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, c1.ID, list[0].ID)
+
+	c, err := s.Get(c2.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "http://host1/b", c.URL)
+	_, err = s.Get("unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.Nil(t, s.Delete(c2.ID))
+	assert.ErrorIs(t, s.Delete(c2.ID), ErrNotFound)
+	assert.NoFileExists(t, path.Join(root, c2.GetFileTitle()))
+	assert.NoFileExists(t, path.Join(root, c2.BodyFile))
+
+	require.Nil(t, s.Save(c2))
+	assert.Nil(t, s.Clear())
+	list, err = s.List(nil)
+	require.Nil(t, err)
+	assert.Len(t, list, 0)
+	assert.FileExists(t, path.Join(root, "log.log"))
+}
+
+func TestDirStore_Legacy(t *testing.T) {
+	root := t.TempDir()
+	name := "2024-01-02T030405.000000000.GET"
+	require.Nil(t, os.WriteFile(path.Join(root, name),
+		[]byte(`{"url":"/a","Method":"GET","timestamp":"2024-01-02T03:04:05Z"}`), 0644))
+
+	s := &DirStore{Dir: root}
+	c, err := s.Get(name)
+	require.Nil(t, err)
+	assert.Equal(t, 0, c.SchemaVersion)
+	assert.Equal(t, "/a", c.URL)
+	assert.Nil(t, s.Delete(name))
+	assert.NoFileExists(t, path.Join(root, name))
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	c1 := newTestCapture(t, "POST", "http://host1/a", ts)
+	c2 := newTestCapture(t, "GET", "http://host1/b", ts.Add(time.Second))
+	require.Nil(t, s.Save(c1))
+	require.Nil(t, s.Save(c2))
+
+	list, err := s.List(nil)
+	require.Nil(t, err)
+	assert.Equal(t, []*CapturedRequest{c1, c2}, list)
+
+	list, err = s.List(&Filter{Method: "GET"})
+	require.Nil(t, err)
+	assert.Equal(t, []*CapturedRequest{c2}, list)
+
+	c, err := s.Get(c2.ID)
+	require.Nil(t, err)
+	assert.Same(t, c2, c)
+	_, err = s.Get("unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.Nil(t, s.Delete(c1.ID))
+	assert.ErrorIs(t, s.Delete(c1.ID), ErrNotFound)
+	list, err = s.List(nil)
+	require.Nil(t, err)
+	assert.Equal(t, []*CapturedRequest{c2}, list)
+
+	assert.Nil(t, s.Clear())
+	list, err = s.List(nil)
+	require.Nil(t, err)
+	assert.Len(t, list, 0)
+}
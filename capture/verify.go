@@ -0,0 +1,238 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default maximum number of near-misses reported by Verify().
+const DEFAULT_MAX_NEAR_MISSES = 5
+
+// Criteria used to verify the received requests. Empty fields match all
+// requests.
+type Criteria struct {
+	Method string `json:"method,omitempty"`
+	// Regular expression tested against the URL path.
+	Path string `json:"path,omitempty"`
+	// Regular expressions tested against the values of the headers. An empty
+	// expression only requires the presence of the header.
+	Headers map[string]string `json:"headers,omitempty"`
+	// The body must contain this string.
+	BodyContains string `json:"bodyContains,omitempty"`
+	// Regular expression tested against the body.
+	BodyPattern string `json:"bodyPattern,omitempty"`
+	// Time window.
+	Since *time.Time `json:"since,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+	// Expected exact number of matching requests.
+	Count *int `json:"count,omitempty"`
+	// Expected minimum number of matching requests. If no expectation is set,
+	// at least one request is expected.
+	AtLeast *int `json:"atLeast,omitempty"`
+	// Expected maximum number of matching requests.
+	AtMost *int `json:"atMost,omitempty"`
+}
+
+// A request that failed some of the conditions of the criteria.
+type NearMiss struct {
+	Request *CapturedRequest `json:"request"`
+	// The conditions that failed.
+	Failed []string `json:"failed"`
+}
+
+// The result of a verification.
+type VerifyResult struct {
+	// True if the number of matching requests meets the expectation.
+	Matched bool `json:"matched"`
+	Count   int  `json:"count"`
+	// Description of the expectation.
+	Expected string             `json:"expected"`
+	Matches  []*CapturedRequest `json:"matches"`
+	// The requests that failed the fewest conditions. Only set if Matched is
+	// false.
+	NearMisses []NearMiss `json:"nearMisses,omitempty"`
+}
+
+// Criteria with its regular expressions compiled.
+type compiledCriteria struct {
+	*Criteria
+	path        *regexp.Regexp
+	headers     map[string]*regexp.Regexp
+	bodyPattern *regexp.Regexp
+}
+
+func (c *Criteria) compile() (*compiledCriteria, error) {
+	ret := &compiledCriteria{
+		Criteria: c,
+		headers:  make(map[string]*regexp.Regexp),
+	}
+	var err error
+	if c.Path != "" {
+		if ret.path, err = regexp.Compile(c.Path); err != nil {
+			return nil, fmt.Errorf("invalid path: %w", err)
+		}
+	}
+	for name, pattern := range c.Headers {
+		if pattern == "" {
+			ret.headers[name] = nil
+		} else if ret.headers[name], err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid header %s: %w", name, err)
+		}
+	}
+	if c.BodyPattern != "" {
+		if ret.bodyPattern, err = regexp.Compile(c.BodyPattern); err != nil {
+			return nil, fmt.Errorf("invalid bodyPattern: %w", err)
+		}
+	}
+	return ret, nil
+}
+
+// Checks if the regular expressions of the criteria are valid.
+func (c *Criteria) Validate() error {
+	_, err := c.compile()
+	return err
+}
+
+// Returns true if any value of the header matches the pattern.
+func matchHeader(r *CapturedRequest, name string, pattern *regexp.Regexp) bool {
+	for k, values := range r.Headers {
+		if !strings.EqualFold(k, name) {
+			continue
+		}
+		for _, v := range values {
+			if pattern == nil || pattern.MatchString(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the names of the conditions that the request fails.
+func (c *compiledCriteria) check(r *CapturedRequest) []string {
+	var failed []string
+	if c.Method != "" && !strings.EqualFold(c.Method, r.Method) {
+		failed = append(failed, "method")
+	}
+	if c.path != nil && !c.path.MatchString(r.Path()) {
+		failed = append(failed, "path")
+	}
+	names := make([]string, 0, len(c.headers))
+	for name := range c.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !matchHeader(r, name, c.headers[name]) {
+			failed = append(failed, "header "+name)
+		}
+	}
+	if c.BodyContains != "" && !bytes.Contains(r.Body, []byte(c.BodyContains)) {
+		failed = append(failed, "bodyContains")
+	}
+	if c.bodyPattern != nil && !c.bodyPattern.Match(r.Body) {
+		failed = append(failed, "bodyPattern")
+	}
+	if c.Since != nil && r.Timestamp.Before(*c.Since) {
+		failed = append(failed, "since")
+	}
+	if c.Until != nil && r.Timestamp.After(*c.Until) {
+		failed = append(failed, "until")
+	}
+	return failed
+}
+
+// Checks if the count meets the expectation. Also returns its description.
+func (c *Criteria) expect(count int) (bool, string) {
+	ok := true
+	var desc []string
+	if c.Count != nil {
+		ok = ok && count == *c.Count
+		desc = append(desc, fmt.Sprintf("exactly %d", *c.Count))
+	}
+	if c.AtLeast != nil {
+		ok = ok && count >= *c.AtLeast
+		desc = append(desc, fmt.Sprintf("at least %d", *c.AtLeast))
+	}
+	if c.AtMost != nil {
+		ok = ok && count <= *c.AtMost
+		desc = append(desc, fmt.Sprintf("at most %d", *c.AtMost))
+	}
+	if len(desc) == 0 {
+		return count >= 1, "at least 1"
+	}
+	return ok, strings.Join(desc, " and ")
+}
+
+/*
+Verifies the requests captured by the store against the criteria. If the
+expectation is not met, up to maxNearMisses requests that failed the fewest
+conditions are reported as near-misses. A negative maxNearMisses is handled as
+zero.
+*/
+func Verify(store Store, criteria *Criteria, maxNearMisses int) (*VerifyResult, error) {
+	c, err := criteria.compile()
+	if err != nil {
+		return nil, err
+	}
+	all, err := store.List(nil)
+	if err != nil {
+		return nil, err
+	}
+	ret := &VerifyResult{
+		Matches: make([]*CapturedRequest, 0),
+	}
+	var misses []NearMiss
+	for _, r := range all {
+		if failed := c.check(r); len(failed) == 0 {
+			ret.Matches = append(ret.Matches, r)
+		} else {
+			misses = append(misses, NearMiss{Request: r, Failed: failed})
+		}
+	}
+	ret.Count = len(ret.Matches)
+	ret.Matched, ret.Expected = criteria.expect(ret.Count)
+	if !ret.Matched {
+		sort.SliceStable(misses, func(i, j int) bool {
+			return len(misses[i].Failed) < len(misses[j].Failed)
+		})
+		if maxNearMisses < 0 {
+			maxNearMisses = 0
+		}
+		if len(misses) > maxNearMisses {
+			misses = misses[:maxNearMisses]
+		}
+		ret.NearMisses = misses
+	}
+	return ret, nil
+}
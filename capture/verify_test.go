@@ -0,0 +1,129 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package capture
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func newVerifyStores(t *testing.T, ts time.Time) []Store {
+	stores := []Store{NewMemoryStore(), &DirStore{Dir: t.TempDir()}}
+	for _, s := range stores {
+		for i, body := range []string{`{"id":1}`, `{"id":2}`, `{"id":3}`} {
+			r := httptest.NewRequest("POST", "http://host1/orders", strings.NewReader(body))
+			if i < 2 {
+				r.Header.Set("X-Idempotency-Key", "k"+body)
+			}
+			c, err := NewFromRequest(r, 1000)
+			require.Nil(t, err)
+			c.Timestamp = ts.Add(time.Duration(i) * time.Second)
+			require.Nil(t, s.Save(&c))
+		}
+		c := newTestCapture(t, "GET", "http://host1/orders", ts.Add(3*time.Second))
+		require.Nil(t, s.Save(c))
+	}
+	return stores
+}
+
+func TestVerify(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, s := range newVerifyStores(t, ts) {
+		criteria := &Criteria{
+			Method:  "post",
+			Path:    "^/orders$",
+			Headers: map[string]string{"x-idempotency-key": ""},
+			Count:   intPtr(2),
+		}
+		result, err := Verify(s, criteria, 5)
+		require.Nil(t, err)
+		assert.True(t, result.Matched)
+		assert.Equal(t, 2, result.Count)
+		assert.Equal(t, "exactly 2", result.Expected)
+		assert.Len(t, result.Matches, 2)
+		assert.Nil(t, result.NearMisses)
+
+		// Mismatch with near-misses
+		criteria.Count = intPtr(3)
+		result, err = Verify(s, criteria, 1)
+		require.Nil(t, err)
+		assert.False(t, result.Matched)
+		assert.Equal(t, 2, result.Count)
+		require.Len(t, result.NearMisses, 1)
+		assert.Equal(t, []byte(`{"id":3}`), result.NearMisses[0].Request.Body)
+		assert.Equal(t, []string{"header x-idempotency-key"}, result.NearMisses[0].Failed)
+
+		// No near-misses
+		result, err = Verify(s, criteria, -1)
+		require.Nil(t, err)
+		assert.False(t, result.Matched)
+		assert.Empty(t, result.NearMisses)
+
+		// Body and time window
+		since := ts.Add(time.Second)
+		result, err = Verify(s, &Criteria{BodyContains: `"id"`, BodyPattern: `[23]`, Since: &since}, 5)
+		require.Nil(t, err)
+		assert.True(t, result.Matched)
+		assert.Equal(t, 2, result.Count)
+		assert.Equal(t, "at least 1", result.Expected)
+
+		until := ts
+		result, err = Verify(s, &Criteria{Method: "GET", Until: &until, AtLeast: intPtr(1), AtMost: intPtr(2)}, 5)
+		require.Nil(t, err)
+		assert.False(t, result.Matched)
+		assert.Equal(t, "at least 1 and at most 2", result.Expected)
+		require.Len(t, result.NearMisses, 4)
+		assert.Equal(t, []string{"method"}, result.NearMisses[0].Failed)
+		assert.Equal(t, []string{"until"}, result.NearMisses[1].Failed)
+
+		result, err = Verify(s, &Criteria{Method: "PUT", Count: intPtr(0)}, 5)
+		require.Nil(t, err)
+		assert.True(t, result.Matched)
+		assert.NotNil(t, result.Matches)
+	}
+}
+
+func TestCriteria_Validate(t *testing.T) {
+	assert.Nil(t, (&Criteria{Path: "a", Headers: map[string]string{"a": "b"}, BodyPattern: "c"}).Validate())
+	assert.ErrorContains(t, (&Criteria{Path: "["}).Validate(), "invalid path")
+	assert.ErrorContains(t, (&Criteria{Headers: map[string]string{"a": "["}}).Validate(), "invalid header a")
+	assert.ErrorContains(t, (&Criteria{BodyPattern: "["}).Validate(), "invalid bodyPattern")
+
+	_, err := Verify(NewMemoryStore(), &Criteria{Path: "["}, 5)
+	assert.NotNil(t, err)
+}
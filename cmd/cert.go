@@ -0,0 +1,77 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/engine"
+)
+
+var (
+	certFormat string
+	certOutput string
+)
+
+// certCmd represents the cert command
+var certCmd = &cobra.Command{
+	Use:   "cert [--format pem|der] [--output <file>]",
+	Short: "Exports the automatic CA certificate.",
+	Long: `Exports the automatic CA certificate.
+
+The CA and the server certificate are created inside the directory of the
+automatic certificates if they do not exist yet. The CA is written to the
+standard output unless an output file is specified.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var out io.Writer = os.Stdout
+		if certOutput != "" {
+			f, err := os.Create(certOutput)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		auto, err := engine.ExportAutoCA(configFile, certFormat, out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "CA certificate: %s\nCA fingerprint (SHA-256): %s\n", auto.CACertFile, auto.CAFingerprint)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(certCmd)
+
+	certCmd.Flags().StringVarP(&certFormat, "format", "f", "pem", "Output format, pem or der.")
+	certCmd.Flags().StringVarP(&certOutput, "output", "o", "", "Output file. Defaults to the standard output.")
+}
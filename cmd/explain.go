@@ -0,0 +1,113 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/engine"
+)
+
+var (
+	explainServer  string
+	explainHeaders []string
+	explainData    string
+)
+
+// Creates the request described by the arguments and flags of explain.
+func newExplainRequest(method string, url string) (*http.Request, error) {
+	var body io.Reader
+	if strings.HasPrefix(explainData, "@") {
+		data, err := os.ReadFile(strings.TrimPrefix(explainData, "@"))
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	} else if explainData != "" {
+		body = strings.NewReader(explainData)
+	}
+	request, err := http.NewRequest(strings.ToUpper(method), url, body)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range explainHeaders {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header '%s'", h)
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if strings.EqualFold(name, "Host") {
+			request.Host = value
+		} else {
+			request.Header.Add(name, value)
+		}
+	}
+	return request, nil
+}
+
+// explainCmd represents the explain command
+var explainCmd = &cobra.Command{
+	Use:   "explain [--server <name>] [-H <header>]... [-d <body>] <method> <url>",
+	Short: "Shows which rule would reply to a request.",
+	Long: `Shows which rule would reply to a request.
+
+Loads the configuration file and checks the rules in order against the request,
+showing if each one matched and which conditions failed, followed by the
+response that would be sent. The URL may be a path, like /a?b=1, or a complete
+URL; its host, or the Host header, selects the virtual host. The body may be
+read from a file using -d @<file>. Nothing is captured.
+`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		request, err := newExplainRequest(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		explanation, err := engine.ExplainRequest(configFile, explainServer, request)
+		if err != nil {
+			return err
+		}
+		explanation.Write(os.Stdout)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(explainCmd)
+
+	explainCmd.Flags().StringVarP(&explainServer, "server", "s", "", "Name of the server. Defaults to the main server.")
+	explainCmd.Flags().StringArrayVarP(&explainHeaders, "header", "H", nil, "Header of the request, like 'Name: value'.")
+	explainCmd.Flags().StringVarP(&explainData, "data", "d", "", "Body of the request or @<file>.")
+}
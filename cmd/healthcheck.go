@@ -0,0 +1,64 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/engine"
+)
+
+var (
+	healthServer  string
+	healthLive    bool
+	healthTimeout time.Duration
+)
+
+// healthcheckCmd represents the healthcheck command
+var healthcheckCmd = &cobra.Command{
+	Use:   "healthcheck [--server <name>] [--live] [--timeout <duration>]",
+	Short: "Checks if the server is ready.",
+	Long: `Checks if the server is ready.
+
+Queries the readiness endpoint of the server defined by the configuration file
+and exits with 0 if it is ready or 1 otherwise. The health endpoints must be
+enabled. It is meant to be used by container health checks.
+`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return engine.HealthCheck(configFile, healthServer, healthLive, healthTimeout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(healthcheckCmd)
+
+	healthcheckCmd.Flags().StringVarP(&healthServer, "server", "s", "", "Name of the server. Defaults to the main server.")
+	healthcheckCmd.Flags().BoolVar(&healthLive, "live", false, "Queries the liveness endpoint instead of the readiness endpoint.")
+	healthcheckCmd.Flags().DurationVarP(&healthTimeout, "timeout", "t", 5*time.Second, "Timeout of the request.")
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+var initOptions config.ScaffoldOptions
+
+// initCmd represents the init command
+var initCmd = &cobra.Command{
+	Use:   "init [--preset <name>] [--address <address>] [--capture-dir <dir>] [--force]",
+	Short: "Creates a starter configuration file.",
+	Long: `Creates a starter configuration file.
+
+Writes a commented configuration file, config.yaml or the one set by --config,
+and creates the capture directory. The available presets are:
+
+  basic    A couple of simple responses.
+  rest     A REST CRUD API of items.
+  webhook  A receiver of webhooks.
+  oauth    An OAuth 2.0 authorization server.
+
+Existing files are not overwritten unless --force is set.
+`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := config.Scaffold(configFile, &initOptions)
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("'%s' already exists, use --force to overwrite it", configFile)
+		} else if err != nil {
+			return err
+		}
+		captureDir := initOptions.CaptureDir
+		if captureDir == "" {
+			captureDir = config.DEFAULT_CAPTURE_DIR
+		}
+		fmt.Printf("Created '%s' and the capture directory '%s'.\nStart the server with:\n\n  dummy-http-server -c %s start\n",
+			configFile, captureDir, configFile)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().StringVarP(&initOptions.Preset, "preset", "p", config.DEFAULT_PRESET,
+		"Preset: "+strings.Join(config.Presets(), ", ")+".")
+	initCmd.Flags().StringVarP(&initOptions.Address, "address", "a", config.DEFAULT_ADDRESS, "Binding address.")
+	initCmd.Flags().StringVar(&initOptions.CaptureDir, "capture-dir", config.DEFAULT_CAPTURE_DIR, "Capture directory.")
+	initCmd.Flags().BoolVarP(&initOptions.Force, "force", "f", false, "Overwrites the configuration file if it exists.")
+}
@@ -63,7 +63,6 @@ All non flag parammeters, if any will be tested agains the pattern,
 		for _, s := range args {
 			fmt.Printf(" %s => %v\n", s, p.Match([]byte(s)))
 		}
-		fmt.Print(args)
 		return nil
 	},
 }
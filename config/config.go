@@ -26,27 +26,178 @@
 // OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package config
 
-import "github.com/spf13/viper"
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// The default name of the main server.
+const DEFAULT_SERVER_NAME = "main"
 
 func SetDefaults(v *viper.Viper) {
 
+	v.SetDefault("name", DEFAULT_SERVER_NAME)
 	v.SetDefault("address", ":8080")
+	v.SetDefault("socketMode", "0660")
 	v.SetDefault("captureDir", "var")
 	v.SetDefault("readTimeout", 15)
 	v.SetDefault("writeTimeout", 15)
+	v.SetDefault("shutdownTimeout", 10)
 	v.SetDefault("maxRequestSize", 1024*1024)
+	v.SetDefault("bodyOverflow", "truncate")
+	v.SetDefault("decodeBody", true)
+	v.SetDefault("keepEncodedBody", false)
+	v.SetDefault("watchConfig", false)
+	v.SetDefault("strict", false)
+	v.SetDefault("tls.enabled", false)
+	v.SetDefault("tls.minVersion", "1.2")
+	v.SetDefault("tls.auto", false)
+	v.SetDefault("tls.clientAuth", "none")
+	v.SetDefault("tls.http1Only", false)
+	v.SetDefault("admin.enabled", false)
+	v.SetDefault("admin.prefix", "/_admin")
+	v.SetDefault("admin.address", "")
+	v.SetDefault("unknownHost", "responses")
+	v.SetDefault("logging.level", "info")
+	v.SetDefault("logging.format", "json")
+	v.SetDefault("logging.outputs", []string{"file"})
+	v.SetDefault("logging.file", "")
+	v.SetDefault("logging.sampling.initial", 100)
+	v.SetDefault("logging.sampling.thereafter", 100)
+	v.SetDefault("logging.rotation.maxSize", 100)
+	v.SetDefault("logging.rotation.maxBackups", 3)
+	v.SetDefault("accessLog.enabled", false)
+	v.SetDefault("accessLog.format", "combined")
+	v.SetDefault("accessLog.file", "")
+	v.SetDefault("health.enabled", false)
+	v.SetDefault("health.livePath", "/healthz")
+	v.SetDefault("health.readyPath", "/readyz")
 }
 
 type ResponseConfig struct {
-	PathPattern string
-	Methods     []string
-	ContentType string
-	Body        string
-	SkipCapture bool
-	ReturnCode  int
+	ID          string   `json:"id,omitempty"`
+	PathPattern string   `json:"pathPattern,omitempty"`
+	Methods     []string `json:"methods,omitempty"`
+	ContentType string   `json:"contentType,omitempty"`
+	Body        string   `json:"body,omitempty"`
+	SkipCapture bool     `json:"skipCapture,omitempty"`
+	ReturnCode  int      `json:"returnCode,omitempty"`
+	// Body overflow policy. If empty, uses the server policy.
+	BodyOverflow string `json:"bodyOverflow,omitempty"`
+	// Regular expression matched against the subject of the client certificate.
+	ClientCertSubject string `json:"clientCertSubject,omitempty"`
+	// SHA-256 fingerprint of the client certificate.
+	ClientCertFingerprint string `json:"clientCertFingerprint,omitempty"`
 }
 
-type Config struct {
+type TLSConfig struct {
+	// Enables TLS.
+	Enabled bool
+	// If true, uses certificates issued by a local CA generated automatically
+	// instead of CertFile and KeyFile.
+	Auto bool
+	// Directory of the automatic certificates. Defaults to the capture directory.
+	AutoDir string
+	// Subject alternative names of the automatic certificate.
+	Hosts []string
+	// If true, disables HTTP/2 and only accepts HTTP/1.1.
+	HTTP1Only bool
+	// Client certificate policy: none, request or require.
+	ClientAuth string
+	// CA bundle used to verify the client certificates.
+	ClientCAFile string
+	// Certificate chain file in PEM format.
+	CertFile string
+	// Private key file in PEM format.
+	KeyFile string
+	// Minimum TLS version: 1.0, 1.1, 1.2 or 1.3.
+	MinVersion string
+	// Names of the allowed cipher suites. If empty, uses the Go defaults.
+	CipherSuites []string
+	// ALPN protocols in order of preference. If empty, uses h2 and http/1.1.
+	ALPN []string
+}
+
+type AdminConfig struct {
+	// Enables the admin API.
+	Enabled bool
+	// Path prefix of the admin API.
+	Prefix string
+	// Binding address of the admin listener. If empty, the admin API is served
+	// by the main listener.
+	Address string
+}
+
+type LogSamplingConfig struct {
+	// Number of entries with the same level and message logged each second
+	// before the sampling starts. Zero disables the sampling.
+	Initial int
+	// After Initial, only one of every Thereafter entries is logged.
+	Thereafter int
+}
+
+type LogRotationConfig struct {
+	// Maximum size in megabytes of the log file before it is rotated. Zero
+	// disables the rotation.
+	MaxSize int
+	// Number of rotated files kept.
+	MaxBackups int
+}
+
+type LoggingConfig struct {
+	// Minimum level: debug, info, warn or error.
+	Level string
+	// Format of the entries: json or console.
+	Format string
+	// Destinations of the log: file, stdout or stderr.
+	Outputs []string
+	// Log file. Defaults to log.log inside the capture directory.
+	File string
+	// Sampling of repeated entries.
+	Sampling LogSamplingConfig
+	// Rotation of the log file.
+	Rotation LogRotationConfig
+}
+
+type AccessLogConfig struct {
+	// Enables the access log.
+	Enabled bool
+	// Format of the entries: common, combined or a custom template.
+	Format string
+	// Access log file. Defaults to access.log inside the capture directory.
+	File string
+}
+
+type HealthConfig struct {
+	// Enables the health endpoints.
+	Enabled bool
+	// Path of the liveness endpoint.
+	LivePath string
+	// Path of the readiness endpoint.
+	ReadyPath string
+}
+
+// Group of responses selected by the host name of the request.
+type VirtualHostConfig struct {
+	// Name of the group. Defaults to "vhost-<index>".
+	Name string
+	// Host names of the group. "*" matches any single label, like in
+	// "*.example.com".
+	Hosts []string
+	// Responses
+	Responses []*ResponseConfig
+}
+
+/*
+Additional server that runs in the same process. The settings not defined here
+are inherited from the main server. The capture directory, the timeouts, the
+admin prefix and the unknown host policy are inherited if not set; TLS, admin,
+responses and virtual hosts are not. The health endpoints are always inherited.
+*/
+type ServerConfig struct {
+	// Name of the server. It is used to tag its captures.
+	Name string
 	// Binding address.
 	Address string
 	// Capture directory.
@@ -55,10 +206,64 @@ type Config struct {
 	ReadTimeout int
 	// Write timeout in seconds.
 	WriteTimeout int
+	// TLS settings.
+	TLS TLSConfig
+	// Admin API.
+	Admin AdminConfig
+	// Responses
+	Responses []*ResponseConfig
+	// Virtual hosts.
+	VirtualHosts []*VirtualHostConfig
+	// Policy for requests that match no virtual host.
+	UnknownHost string
+}
+
+type Config struct {
+	// Name of the main server. It is used to tag its captures.
+	Name string
+	// Binding address. Unix domain sockets use the format "unix:<path>".
+	Address string
+	// Permissions of the Unix domain sockets in octal.
+	SocketMode string
+	// Capture directory.
+	CaptureDir string
+	// Read timeout in seconds.
+	ReadTimeout int
+	// Write timeout in seconds.
+	WriteTimeout int
+	// Time in seconds given to the requests being handled to finish on shutdown.
+	ShutdownTimeout int
 	// Maximum request size in bytes.
 	MaxRequestSize int
+	// Policy for request bodies larger than MaxRequestSize.
+	BodyOverflow string
+	// If true, decodes request bodies with Content-Encoding.
+	DecodeBody bool
+	// If true, keeps the encoded request body in the capture.
+	KeepEncodedBody bool
+	// TLS settings.
+	TLS TLSConfig
+	// If true, reloads the responses when the configuration file changes.
+	WatchConfig bool
+	// If true, the server does not start if the configuration has any problem.
+	Strict bool
+	// Admin API.
+	Admin AdminConfig
+	// Log settings. The log is shared by all servers.
+	Logging LoggingConfig
+	// Access log settings. The access log is shared by all servers.
+	AccessLog AccessLogConfig
+	// Health endpoints.
+	Health HealthConfig
 	// Responses
 	Responses []*ResponseConfig
+	// Virtual hosts.
+	VirtualHosts []*VirtualHostConfig
+	// Policy for requests that match no virtual host: responses, default or
+	// reject.
+	UnknownHost string
+	// Additional servers.
+	Servers []*ServerConfig
 	// Source configuration.
 	source *viper.Viper
 }
@@ -67,6 +272,76 @@ func (c *Config) GetSource() *viper.Viper {
 	return c.source
 }
 
+/*
+Returns the configuration of each server, starting with the main one. The
+returned configurations share the source of this configuration and have no
+additional servers.
+*/
+func (c *Config) ServerConfigs() ([]*Config, error) {
+	main := *c
+	main.Servers = nil
+	ret := []*Config{&main}
+	names := map[string]bool{c.Name: true}
+	addresses := map[string]bool{c.Address: true}
+	for i, s := range c.Servers {
+		if s.Name == "" {
+			return nil, fmt.Errorf("server %d: the name is required", i)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("server %d: duplicated name '%s'", i, s.Name)
+		}
+		names[s.Name] = true
+		if s.Address == "" {
+			return nil, fmt.Errorf("server %d: the address is required", i)
+		}
+		if addresses[s.Address] {
+			return nil, fmt.Errorf("server %d: duplicated address '%s'", i, s.Address)
+		}
+		addresses[s.Address] = true
+		server := main
+		server.Name = s.Name
+		server.Address = s.Address
+		if s.CaptureDir != "" {
+			server.CaptureDir = s.CaptureDir
+		}
+		if s.ReadTimeout != 0 {
+			server.ReadTimeout = s.ReadTimeout
+		}
+		if s.WriteTimeout != 0 {
+			server.WriteTimeout = s.WriteTimeout
+		}
+		server.TLS = s.TLS
+		server.Admin = s.Admin
+		if server.Admin.Prefix == "" {
+			server.Admin.Prefix = c.Admin.Prefix
+		}
+		server.Responses = s.Responses
+		server.VirtualHosts = s.VirtualHosts
+		if s.UnknownHost != "" {
+			server.UnknownHost = s.UnknownHost
+		}
+		ret = append(ret, &server)
+	}
+	return ret, nil
+}
+
+/*
+Returns the configuration of the server with the given name. See
+ServerConfigs() for further details.
+*/
+func (c *Config) ServerConfig(name string) (*Config, error) {
+	configs, err := c.ServerConfigs()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range configs {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown server '%s'", name)
+}
+
 func LoadConfig(file string) (*Config, error) {
 	v := viper.New()
 	SetDefaults(v)
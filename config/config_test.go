@@ -31,6 +31,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -38,11 +39,42 @@ func TestLoadConfig(t *testing.T) {
 	c, err := LoadConfig(file)
 	assert.Nil(t, err)
 	assert.NotNil(t, c)
+	assert.Equal(t, "main", c.Name)
 	assert.Equal(t, ":8080", c.Address)
+	assert.Equal(t, "0660", c.SocketMode)
 	assert.Equal(t, "var", c.CaptureDir)
 	assert.Equal(t, 15, c.ReadTimeout)
 	assert.Equal(t, 15, c.WriteTimeout)
+	assert.Equal(t, 10, c.ShutdownTimeout)
 	assert.Equal(t, 1024*1024, c.MaxRequestSize)
+	assert.Equal(t, "truncate", c.BodyOverflow)
+	assert.True(t, c.DecodeBody)
+	assert.False(t, c.KeepEncodedBody)
+	assert.False(t, c.TLS.Enabled)
+	assert.Equal(t, "1.2", c.TLS.MinVersion)
+	assert.False(t, c.TLS.Auto)
+	assert.False(t, c.TLS.HTTP1Only)
+	assert.Equal(t, "none", c.TLS.ClientAuth)
+	assert.False(t, c.Admin.Enabled)
+	assert.Equal(t, "/_admin", c.Admin.Prefix)
+	assert.Equal(t, "", c.Admin.Address)
+	assert.False(t, c.Health.Enabled)
+	assert.False(t, c.AccessLog.Enabled)
+	assert.False(t, c.Strict)
+	assert.Equal(t, "combined", c.AccessLog.Format)
+	assert.Equal(t, "", c.AccessLog.File)
+	assert.Equal(t, "info", c.Logging.Level)
+	assert.Equal(t, "json", c.Logging.Format)
+	assert.Equal(t, []string{"file"}, c.Logging.Outputs)
+	assert.Equal(t, "", c.Logging.File)
+	assert.Equal(t, 100, c.Logging.Sampling.Initial)
+	assert.Equal(t, 100, c.Logging.Sampling.Thereafter)
+	assert.Equal(t, 100, c.Logging.Rotation.MaxSize)
+	assert.Equal(t, 3, c.Logging.Rotation.MaxBackups)
+	assert.Equal(t, "/healthz", c.Health.LivePath)
+	assert.Equal(t, "/readyz", c.Health.ReadyPath)
+	assert.Equal(t, "responses", c.UnknownHost)
+	assert.Nil(t, c.VirtualHosts)
 	assert.Nil(t, c.Responses)
 
 	file = path.Join("..", "_samples", "config-simple.yaml")
@@ -65,15 +97,49 @@ func TestLoadConfig(t *testing.T) {
 	assert.Equal(t, 123, c.ReadTimeout)
 	assert.Equal(t, 456, c.WriteTimeout)
 	assert.Equal(t, 789, c.MaxRequestSize)
+	assert.True(t, c.TLS.Enabled)
+	assert.Equal(t, "cert.pem", c.TLS.CertFile)
+	assert.Equal(t, "key.pem", c.TLS.KeyFile)
+	assert.Equal(t, "1.3", c.TLS.MinVersion)
+	assert.Equal(t, []string{"TLS_AES_128_GCM_SHA256"}, c.TLS.CipherSuites)
+	assert.Equal(t, []string{"http/1.1"}, c.TLS.ALPN)
+	assert.Equal(t, "require", c.TLS.ClientAuth)
+	assert.Equal(t, "clients.pem", c.TLS.ClientCAFile)
+	assert.True(t, c.Admin.Enabled)
+	assert.Equal(t, "/admin", c.Admin.Prefix)
+	assert.Equal(t, "localhost:8081", c.Admin.Address)
+	assert.True(t, c.Health.Enabled)
+	assert.True(t, c.AccessLog.Enabled)
+	assert.True(t, c.Strict)
+	assert.Equal(t, "{{.Method}} {{.URI}} {{.Status}} {{.Rule}}", c.AccessLog.Format)
+	assert.Equal(t, "access-full.log", c.AccessLog.File)
+	assert.Equal(t, "debug", c.Logging.Level)
+	assert.Equal(t, "console", c.Logging.Format)
+	assert.Equal(t, []string{"stdout", "file"}, c.Logging.Outputs)
+	assert.Equal(t, "dummy.log", c.Logging.File)
+	assert.Equal(t, 0, c.Logging.Sampling.Initial)
+	assert.Equal(t, 10, c.Logging.Rotation.MaxSize)
+	assert.Equal(t, 5, c.Logging.Rotation.MaxBackups)
+	assert.Equal(t, "/healthz", c.Health.LivePath)
+	assert.Equal(t, "/ready", c.Health.ReadyPath)
 	assert.Len(t, c.Responses, 2)
+	assert.Equal(t, "reject", c.UnknownHost)
+	require.Len(t, c.VirtualHosts, 1)
+	assert.Equal(t, "api", c.VirtualHosts[0].Name)
+	assert.Equal(t, []string{"api.local", "*.api.local"}, c.VirtualHosts[0].Hosts)
+	require.Len(t, c.VirtualHosts[0].Responses, 1)
+	assert.Equal(t, 202, c.VirtualHosts[0].Responses[0].ReturnCode)
 
+	assert.Equal(t, "rule-b", c.Responses[0].ID)
 	assert.Equal(t, "\\/b.*", c.Responses[0].PathPattern)
 	assert.Equal(t, []string{"GET", "POST"}, c.Responses[0].Methods)
 	assert.Equal(t, "text/plain", c.Responses[0].ContentType)
 	assert.Equal(t, "AAAA", c.Responses[0].Body)
 	assert.True(t, c.Responses[0].SkipCapture)
 	assert.Equal(t, 201, c.Responses[0].ReturnCode)
+	assert.Equal(t, "CN=client", c.Responses[0].ClientCertSubject)
 
+	assert.Equal(t, "", c.Responses[1].ID)
 	assert.Equal(t, "\\/a.*", c.Responses[1].PathPattern)
 	assert.Nil(t, c.Responses[1].Methods)
 	assert.Equal(t, "text/html", c.Responses[1].ContentType)
@@ -81,3 +147,63 @@ func TestLoadConfig(t *testing.T) {
 	assert.False(t, c.Responses[1].SkipCapture)
 	assert.Equal(t, 0, c.Responses[1].ReturnCode)
 }
+
+func TestConfig_ServerConfigs(t *testing.T) {
+	file := path.Join("..", "_samples", "config-servers.yaml")
+	c, err := LoadConfig(file)
+	require.Nil(t, err)
+	configs, err := c.ServerConfigs()
+	require.Nil(t, err)
+	require.Len(t, configs, 3)
+
+	assert.Equal(t, "main", configs[0].Name)
+	assert.Equal(t, ":8080", configs[0].Address)
+	assert.Nil(t, configs[0].Servers)
+	assert.Len(t, configs[0].Responses, 1)
+	assert.Same(t, c.GetSource(), configs[0].GetSource())
+	assert.Len(t, c.Servers, 2)
+
+	assert.Equal(t, "auth", configs[1].Name)
+	assert.Equal(t, ":8081", configs[1].Address)
+	assert.Equal(t, "var", configs[1].CaptureDir)
+	assert.Equal(t, 5, configs[1].ReadTimeout)
+	assert.Equal(t, 15, configs[1].WriteTimeout)
+	assert.Equal(t, 2048, configs[1].MaxRequestSize)
+	assert.True(t, configs[1].TLS.Enabled)
+	assert.True(t, configs[1].TLS.Auto)
+	assert.True(t, configs[1].Admin.Enabled)
+	assert.Equal(t, "/_admin", configs[1].Admin.Prefix)
+	require.Len(t, configs[1].Responses, 1)
+	assert.Equal(t, "^/token$", configs[1].Responses[0].PathPattern)
+	assert.Equal(t, "responses", configs[1].UnknownHost)
+	assert.Same(t, c.GetSource(), configs[1].GetSource())
+
+	assert.Equal(t, "billing", configs[2].Name)
+	assert.Equal(t, "var-billing", configs[2].CaptureDir)
+	assert.False(t, configs[2].TLS.Enabled)
+	assert.False(t, configs[2].Admin.Enabled)
+	assert.Nil(t, configs[2].Responses)
+	assert.Equal(t, "reject", configs[2].UnknownHost)
+	require.Len(t, configs[2].VirtualHosts, 1)
+
+	s, err := c.ServerConfig("billing")
+	require.Nil(t, err)
+	assert.Equal(t, configs[2], s)
+	_, err = c.ServerConfig("x")
+	assert.ErrorContains(t, err, "unknown server 'x'")
+
+	for msg, servers := range map[string][]*ServerConfig{
+		"server 0: the name is required":             {{Address: ":1"}},
+		"server 0: duplicated name 'main'":           {{Name: "main", Address: ":1"}},
+		"server 1: duplicated name 'a'":              {{Name: "a", Address: ":1"}, {Name: "a", Address: ":2"}},
+		"server 0: the address is required":          {{Name: "a"}},
+		"server 1: duplicated address ':1'":          {{Name: "a", Address: ":1"}, {Name: "b", Address: ":1"}},
+		"server 0: duplicated address 'localhost:0'": {{Name: "a", Address: "localhost:0"}},
+	} {
+		c := &Config{Name: "main", Address: "localhost:0", Servers: servers}
+		_, err := c.ServerConfigs()
+		assert.ErrorContains(t, err, msg)
+		_, err = c.ServerConfig("main")
+		assert.ErrorContains(t, err, msg)
+	}
+}
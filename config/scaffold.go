@@ -0,0 +1,140 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package config
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	// Preset used when none is specified.
+	DEFAULT_PRESET = "basic"
+	// Default binding address of the starter configurations.
+	DEFAULT_ADDRESS = ":8080"
+	// Default capture directory of the starter configurations.
+	DEFAULT_CAPTURE_DIR = "var"
+)
+
+// Templates of the starter configurations.
+//
+//go:embed presets/*.yaml
+var presetFiles embed.FS
+
+// Options of a starter configuration.
+type ScaffoldOptions struct {
+	// Name of the preset. Defaults to DEFAULT_PRESET.
+	Preset string
+	// Binding address. Defaults to DEFAULT_ADDRESS.
+	Address string
+	// Capture directory. Defaults to DEFAULT_CAPTURE_DIR.
+	CaptureDir string
+	// If true, overwrites the configuration file if it exists.
+	Force bool
+}
+
+// Returns the names of the available presets.
+func Presets() []string {
+	entries, _ := fs.ReadDir(presetFiles, "presets")
+	var ret []string
+	for _, entry := range entries {
+		ret = append(ret, strings.TrimSuffix(entry.Name(), path.Ext(entry.Name())))
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+/*
+Returns the starter configuration of the given preset. Bodies are written in
+base64 after a comment with their contents.
+*/
+func NewStarterConfig(options *ScaffoldOptions) ([]byte, error) {
+	values := *options
+	if values.Preset == "" {
+		values.Preset = DEFAULT_PRESET
+	}
+	if values.Address == "" {
+		values.Address = DEFAULT_ADDRESS
+	}
+	if values.CaptureDir == "" {
+		values.CaptureDir = DEFAULT_CAPTURE_DIR
+	}
+	data, err := presetFiles.ReadFile("presets/" + values.Preset + ".yaml")
+	if err != nil {
+		return nil, fmt.Errorf("unknown preset '%s', use one of %s", values.Preset,
+			strings.Join(Presets(), ", "))
+	}
+	tmpl, err := template.New(values.Preset).Funcs(template.FuncMap{
+		"quote": strconv.Quote,
+		"base64": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+	}).Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	var buff bytes.Buffer
+	if err := tmpl.Execute(&buff, &values); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+/*
+Writes a starter configuration into the given file and creates its capture
+directory. It fails with os.ErrExist if the file exists, unless options.Force is
+set.
+*/
+func Scaffold(file string, options *ScaffoldOptions) error {
+	data, err := NewStarterConfig(options)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); err == nil && !options.Force {
+		return fmt.Errorf("'%s': %w", file, os.ErrExist)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	captureDir := options.CaptureDir
+	if captureDir == "" {
+		captureDir = DEFAULT_CAPTURE_DIR
+	}
+	if err := os.MkdirAll(captureDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
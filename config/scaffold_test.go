@@ -0,0 +1,106 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package config
+
+import (
+	"encoding/base64"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresets(t *testing.T) {
+	assert.Equal(t, []string{"basic", "oauth", "rest", "webhook"}, Presets())
+}
+
+func TestNewStarterConfig(t *testing.T) {
+	data, err := NewStarterConfig(&ScaffoldOptions{})
+	require.Nil(t, err)
+	assert.Contains(t, string(data), "address: \":8080\"\n")
+	assert.Contains(t, string(data), "captureDir: \"var\"\n")
+	assert.Contains(t, string(data), "# Hello, world!\n    body: SGVsbG8sIHdvcmxkIQo=\n")
+
+	_, err = NewStarterConfig(&ScaffoldOptions{Preset: "bogus"})
+	assert.ErrorContains(t, err, "unknown preset 'bogus', use one of basic, oauth, rest, webhook")
+}
+
+func TestScaffold_Presets(t *testing.T) {
+	root := t.TempDir()
+	for _, preset := range Presets() {
+		file := path.Join(root, preset+".yaml")
+		captureDir := path.Join(root, "var-"+preset)
+		require.Nil(t, Scaffold(file, &ScaffoldOptions{Preset: preset, Address: "127.0.0.1:9090", CaptureDir: captureDir}))
+		assert.DirExists(t, captureDir)
+
+		c, err := LoadConfig(file)
+		require.Nil(t, err, preset)
+		assert.Equal(t, "127.0.0.1:9090", c.Address)
+		assert.Equal(t, captureDir, c.CaptureDir)
+		assert.True(t, c.Strict)
+		assert.NotEmpty(t, c.Responses)
+		for _, r := range c.Responses {
+			_, err := regexp.Compile(r.PathPattern)
+			assert.Nil(t, err, r.ID)
+			_, err = base64.StdEncoding.DecodeString(r.Body)
+			assert.Nil(t, err, r.ID)
+		}
+
+		m, err := LoadSourceMap(file)
+		require.Nil(t, err)
+		assert.Nil(t, m.UnknownKeys(), preset)
+	}
+}
+
+func TestScaffold_Force(t *testing.T) {
+	root := t.TempDir()
+	file := path.Join(root, "config.yaml")
+	require.Nil(t, os.WriteFile(file, []byte("old"), 0644))
+	options := &ScaffoldOptions{CaptureDir: path.Join(root, "var")}
+
+	assert.ErrorIs(t, Scaffold(file, options), os.ErrExist)
+	data, err := os.ReadFile(file)
+	require.Nil(t, err)
+	assert.Equal(t, "old", string(data))
+	assert.NoDirExists(t, options.CaptureDir)
+
+	options.Force = true
+	assert.Nil(t, Scaffold(file, options))
+	data, err = os.ReadFile(file)
+	require.Nil(t, err)
+	assert.Contains(t, string(data), "responses:")
+
+	// Unknown presets do not touch the files
+	options.Preset = "bogus"
+	assert.NotNil(t, Scaffold(file, options))
+	data, err = os.ReadFile(file)
+	require.Nil(t, err)
+	assert.Contains(t, string(data), "responses:")
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Locates the settings inside a YAML configuration file. The settings are
+identified by paths like "servers[0].responses[1].pathPattern". Like in
+LoadConfig(), the keys are case-insensitive.
+*/
+type SourceMap struct {
+	root *yaml.Node
+}
+
+// Parses the given YAML file. JSON files are also accepted.
+func LoadSourceMap(file string) (*SourceMap, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	ret := &SourceMap{}
+	if len(doc.Content) > 0 {
+		ret.root = doc.Content[0]
+	}
+	return ret, nil
+}
+
+// Splits a path into keys and sequence indexes.
+func splitPath(path string) []string {
+	var ret []string
+	for _, part := range strings.Split(path, ".") {
+		for {
+			i := strings.IndexByte(part, '[')
+			if i < 0 {
+				break
+			}
+			if i > 0 {
+				ret = append(ret, part[:i])
+			}
+			j := strings.IndexByte(part, ']')
+			if j < i {
+				break
+			}
+			ret = append(ret, part[i:j+1])
+			part = part[j+1:]
+		}
+		if part != "" {
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
+
+// Returns the child of the node, or nil if it does not exist.
+func childNode(node *yaml.Node, key string) *yaml.Node {
+	if strings.HasPrefix(key, "[") {
+		index, err := strconv.Atoi(strings.Trim(key, "[]"))
+		if err != nil || node.Kind != yaml.SequenceNode || index < 0 || index >= len(node.Content) {
+			return nil
+		}
+		return node.Content[index]
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+/*
+Returns the line of the setting with the given path. If the setting is not in
+the file, returns the line of its closest parent or 0 if there is none.
+*/
+func (m *SourceMap) Line(path string) int {
+	if m.root == nil {
+		return 0
+	}
+	node := m.root
+	line := 0
+	for _, key := range splitPath(path) {
+		if node = childNode(node, key); node == nil {
+			break
+		}
+		line = node.Line
+	}
+	return line
+}
+
+/*
+Returns the paths of the keys that do not match any setting of Config, in the
+order they appear in the file.
+*/
+func (m *SourceMap) UnknownKeys() []string {
+	if m.root == nil {
+		return nil
+	}
+	var ret []string
+	unknownKeys(m.root, reflect.TypeOf(Config{}), "", &ret)
+	return ret
+}
+
+// Returns the field of the struct type with the given name, ignoring the case.
+func findField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.IsExported() && strings.EqualFold(field.Name, name) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func unknownKeys(node *yaml.Node, t reflect.Type, path string, ret *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			field, ok := findField(t, key)
+			if !ok {
+				*ret = append(*ret, keyPath)
+				continue
+			}
+			unknownKeys(node.Content[i+1], field.Type, keyPath, ret)
+		}
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for i, item := range node.Content {
+			unknownKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), ret)
+		}
+	}
+}
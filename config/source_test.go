@@ -0,0 +1,99 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitPath(t *testing.T) {
+	assert.Equal(t, []string{"a"}, splitPath("a"))
+	assert.Equal(t, []string{"a", "b"}, splitPath("a.b"))
+	assert.Equal(t, []string{"servers", "[0]", "responses", "[1]", "methods", "[2]"},
+		splitPath("servers[0].responses[1].methods[2]"))
+}
+
+func TestSourceMap(t *testing.T) {
+	file := path.Join(t.TempDir(), "config.yaml")
+	require.Nil(t, os.WriteFile(file, []byte(`address: ":8080"
+adress: ":8081"
+tls:
+  enabled: true
+  certfile: cert.pem
+  certFiles: cert.pem
+responses:
+  - pathPattern: ^/a
+    methods:
+      - GET
+  - pathPatern: ^/b
+servers:
+  - name: s1
+    virtualHosts:
+      - hosts: [a]
+        bogus: 1
+`), 0644))
+	m, err := LoadSourceMap(file)
+	require.Nil(t, err)
+
+	assert.Equal(t, []string{"adress", "tls.certFiles", "responses[1].pathPatern",
+		"servers[0].virtualHosts[0].bogus"}, m.UnknownKeys())
+
+	assert.Equal(t, 1, m.Line("address"))
+	assert.Equal(t, 5, m.Line("tls.certFile"))
+	assert.Equal(t, 8, m.Line("responses[0].pathPattern"))
+	assert.Equal(t, 10, m.Line("responses[0].methods[0]"))
+	// Closest parent
+	assert.Equal(t, 11, m.Line("responses[1].returnCode"))
+	assert.Equal(t, 13, m.Line("servers[0].captureDir"))
+	assert.Equal(t, 0, m.Line("captureDir"))
+	assert.Equal(t, 8, m.Line("responses[5]"))
+
+	_, err = LoadSourceMap(path.Join(t.TempDir(), "missing.yaml"))
+	assert.NotNil(t, err)
+}
+
+func TestSourceMap_Empty(t *testing.T) {
+	file := path.Join(t.TempDir(), "config.yaml")
+	require.Nil(t, os.WriteFile(file, nil, 0644))
+	m, err := LoadSourceMap(file)
+	require.Nil(t, err)
+	assert.Nil(t, m.UnknownKeys())
+	assert.Equal(t, 0, m.Line("address"))
+}
+
+func TestSourceMap_Samples(t *testing.T) {
+	for _, file := range []string{"config-full.yaml", "config-servers.yaml"} {
+		m, err := LoadSourceMap(path.Join("..", "_samples", file))
+		require.Nil(t, err)
+		assert.Nil(t, m.UnknownKeys(), file)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+/*
+Package dummytest runs the dummy HTTP server in-process for Go tests.
+
+	func TestClient(t *testing.T) {
+		b := &engine.ResponseBuilder{}
+		b.SetPathPatternStr("^/orders$")
+		srv := dummytest.New(t, b.AddMethod("POST").SetResponseCode(201))
+
+		// Use srv.URL as the base URL of the client
+		...
+
+		count := 2
+		srv.AssertReceived(t, capture.Criteria{Method: "POST", Path: "^/orders$", Count: &count})
+	}
+
+The captured requests are kept in memory and the server is closed automatically
+when the test finishes.
+*/
+package dummytest
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/engine"
+	"go.uber.org/zap"
+)
+
+// An in-process dummy HTTP server.
+type Server struct {
+	// The underlying test server. Its URL is the base URL of the dummy server.
+	*httptest.Server
+	// The engine that handles the requests.
+	Engine *engine.Engine
+	// The captured requests.
+	Store *capture.MemoryStore
+	// The responses used by Reset().
+	initial []engine.Response
+}
+
+/*
+Returns the default configuration used by New().
+*/
+func DefaultConfig() *config.Config {
+	return &config.Config{
+		MaxRequestSize: 1024 * 1024,
+		BodyOverflow:   string(engine.OVERFLOW_TRUNCATE),
+		DecodeBody:     true,
+	}
+}
+
+/*
+Starts a new server with the given responses. They are matched in the given
+order. The server is closed when the test finishes.
+*/
+func New(t testing.TB, responses ...*engine.ResponseBuilder) *Server {
+	t.Helper()
+	return NewWithConfig(t, DefaultConfig(), responses...)
+}
+
+/*
+Starts a new server using the given configuration. The responses of the
+configuration are followed by the given responses. The configuration must not be
+modified afterwards. The server is closed when the test finishes.
+*/
+func NewWithConfig(t testing.TB, cfg *config.Config, responses ...*engine.ResponseBuilder) *Server {
+	t.Helper()
+	store := capture.NewMemoryStore()
+	e, err := engine.NewEngine(cfg, engine.WithLogger(zap.NewNop()), engine.WithStore(store))
+	if err != nil {
+		t.Fatalf("Unable to create the engine: %v", err)
+	}
+	s := &Server{
+		Engine: e,
+		Store:  store,
+	}
+	for _, b := range responses {
+		s.AddResponse(b)
+	}
+	s.initial = e.Responses.Responses()
+	s.Server = httptest.NewServer(e)
+	t.Cleanup(s.Close)
+	return s
+}
+
+/*
+Adds a response to the end of the list. If the builder has no ID, the response
+receives engine.DefaultResponseID() with its position. The builder is not
+changed.
+*/
+func (s *Server) AddResponse(b *engine.ResponseBuilder) {
+	r := b.Build()
+	if r.ID() == "" {
+		clone := *b
+		r = clone.SetID(engine.DefaultResponseID(len(s.Engine.Responses.Responses()))).Build()
+	}
+	s.Engine.Responses.AddResponse(r)
+}
+
+/*
+Returns all captured requests in the order they were received.
+*/
+func (s *Server) Requests() []*capture.CapturedRequest {
+	ret, _ := s.Store.List(nil)
+	return ret
+}
+
+/*
+Removes all captured requests and restores the responses used when the server
+was created.
+*/
+func (s *Server) Reset() {
+	s.Store.Clear()
+	s.Engine.Responses.SetResponses(s.initial)
+}
+
+/*
+Verifies the captured requests against the criteria. See capture.Verify() for
+further details.
+*/
+func (s *Server) Verify(criteria capture.Criteria) (*capture.VerifyResult, error) {
+	return s.Engine.Verify(&criteria)
+}
+
+/*
+Asserts that the captured requests meet the criteria. On failure, it reports the
+near-misses and marks the test as failed. Returns true if the assertion holds.
+*/
+func (s *Server) AssertReceived(t testing.TB, criteria capture.Criteria) bool {
+	t.Helper()
+	result, err := s.Verify(criteria)
+	if err != nil {
+		t.Errorf("Invalid criteria: %v", err)
+		return false
+	}
+	if !result.Matched {
+		t.Errorf("Expected %s matching requests but received %d.%s", result.Expected,
+			result.Count, formatNearMisses(result.NearMisses))
+		return false
+	}
+	return true
+}
+
+// Formats the near-misses for the failure message.
+func formatNearMisses(misses []capture.NearMiss) string {
+	if len(misses) == 0 {
+		return ""
+	}
+	b := strings.Builder{}
+	b.WriteString("\nNear-misses:")
+	for _, m := range misses {
+		fmt.Fprintf(&b, "\n  %s %s (failed: %s)", m.Request.Method, m.Request.URL,
+			strings.Join(m.Failed, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package dummytest
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/engine"
+)
+
+// Records the failures reported by the assertions.
+type recorderT struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorderT) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, format)
+}
+
+func post(t *testing.T, url string, body string) *http.Response {
+	resp, err := http.Post(url, "text/plain", strings.NewReader(body))
+	require.Nil(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestServer(t *testing.T) {
+	b := &engine.ResponseBuilder{}
+	require.Nil(t, b.SetPathPatternStr("^/orders$"))
+	srv := New(t, b.AddMethod("POST").SetResponseCode(201))
+
+	assert.Equal(t, 201, post(t, srv.URL+"/orders", "1").StatusCode)
+	assert.Equal(t, 201, post(t, srv.URL+"/orders", "2").StatusCode)
+	resp, err := http.Get(srv.URL + "/orders")
+	require.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "{}", string(body))
+
+	requests := srv.Requests()
+	require.Len(t, requests, 3)
+	assert.Equal(t, "response-0", requests[0].RuleID)
+	assert.Equal(t, []byte("2"), requests[1].Body)
+	assert.Equal(t, "default", requests[2].RuleID)
+
+	count := 2
+	assert.True(t, srv.AssertReceived(t, capture.Criteria{Method: "POST", Path: "^/orders$", Count: &count}))
+
+	rec := &recorderT{TB: t}
+	count = 3
+	assert.False(t, srv.AssertReceived(rec, capture.Criteria{Method: "POST", Count: &count}))
+	assert.Len(t, rec.errors, 1)
+	assert.False(t, srv.AssertReceived(rec, capture.Criteria{Path: "["}))
+	assert.Len(t, rec.errors, 2)
+
+	// Reset
+	b2 := &engine.ResponseBuilder{}
+	srv.AddResponse(b2.SetResponseCode(202))
+	assert.Equal(t, 202, post(t, srv.URL+"/other", "").StatusCode)
+	// The builder is not changed, thus it can be added again
+	assert.Equal(t, "", b2.Build().ID())
+	srv.AddResponse(b2)
+	assert.NotNil(t, srv.Engine.Responses.Get(engine.DefaultResponseID(2)))
+	srv.Reset()
+	assert.Len(t, srv.Requests(), 0)
+	assert.Equal(t, 200, post(t, srv.URL+"/other", "").StatusCode)
+}
+
+func TestFormatNearMisses(t *testing.T) {
+	assert.Equal(t, "", formatNearMisses(nil))
+	assert.Equal(t, "\nNear-misses:\n  GET /a (failed: method, path)", formatNearMisses([]capture.NearMiss{
+		{Request: &capture.CapturedRequest{Method: "GET", URL: "/a"}, Failed: []string{"method", "path"}},
+	}))
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+const (
+	// Default name of the access log file inside the capture directory.
+	DEFAULT_ACCESS_LOG_FILE = "access.log"
+	ACCESS_LOG_COMMON       = "common"
+	ACCESS_LOG_COMBINED     = "combined"
+	// Template of the Common Log Format.
+	COMMON_LOG_TEMPLATE = `{{.RemoteHost}} - {{.User}} [{{.CLFTime}}] "{{.Method}} {{.URI}} {{.Proto}}" {{.Status}} {{.CLFBytes}}`
+	// Template of the Combined Log Format.
+	COMBINED_LOG_TEMPLATE = COMMON_LOG_TEMPLATE + ` {{quote .Referer}} {{quote .UserAgent}}`
+	// Time layout of the Common Log Format.
+	CLF_TIME_LAYOUT = "02/Jan/2006:15:04:05 -0700"
+)
+
+// Entry of the access log. Its fields are available to the custom templates.
+type AccessLogEntry struct {
+	// Time the request was received.
+	Time time.Time
+	// Name of the server.
+	Server string
+	// Address of the client without the port or "-".
+	RemoteHost string
+	// User of the basic authentication or "-".
+	User      string
+	Method    string
+	URI       string
+	Proto     string
+	Host      string
+	Referer   string
+	UserAgent string
+	// Status of the response.
+	Status int
+	// Number of body bytes sent.
+	Bytes int64
+	// Time spent handling the request.
+	Duration time.Duration
+	// ID of the matched rule.
+	Rule string
+}
+
+// Entry used to check the access log formats.
+var SAMPLE_ACCESS_LOG_ENTRY = &AccessLogEntry{
+	Time:       time.Unix(0, 0).UTC(),
+	Server:     "main",
+	RemoteHost: "127.0.0.1",
+	User:       "-",
+	Method:     http.MethodGet,
+	URI:        "/",
+	Proto:      "HTTP/1.1",
+	Host:       "localhost",
+	Status:     http.StatusOK,
+	Rule:       DEFAULT_RESPONSE_ID,
+}
+
+// Time in the Common Log Format.
+func (e *AccessLogEntry) CLFTime() string {
+	return e.Time.Format(CLF_TIME_LAYOUT)
+}
+
+// Bytes sent in the Common Log Format, "-" if none.
+func (e *AccessLogEntry) CLFBytes() string {
+	if e.Bytes == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(e.Bytes, 10)
+}
+
+// Creates the entry of the given request.
+func newAccessLogEntry(request *http.Request, start time.Time) *AccessLogEntry {
+	remote := request.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if remote == "" || remote == "@" {
+		remote = "-"
+	}
+	user, _, ok := request.BasicAuth()
+	if !ok || user == "" {
+		user = "-"
+	}
+	return &AccessLogEntry{
+		Time:       start,
+		RemoteHost: remote,
+		User:       user,
+		Method:     request.Method,
+		URI:        request.RequestURI,
+		Proto:      request.Proto,
+		Host:       request.Host,
+		Referer:    request.Referer(),
+		UserAgent:  request.UserAgent(),
+	}
+}
+
+// Quotes a field of the access log. Empty values become "-".
+func quoteField(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+// Writes the access log entries using a template.
+type AccessLog struct {
+	mutex    sync.Mutex
+	template *template.Template
+	out      io.Writer
+}
+
+/*
+Creates a new access log. The format may be "common", "combined" or a custom
+template that receives an AccessLogEntry. A new line is appended to each entry.
+*/
+func NewAccessLog(format string, out io.Writer) (*AccessLog, error) {
+	switch strings.ToLower(format) {
+	case "", ACCESS_LOG_COMBINED:
+		format = COMBINED_LOG_TEMPLATE
+	case ACCESS_LOG_COMMON:
+		format = COMMON_LOG_TEMPLATE
+	}
+	tmpl, err := template.New("accessLog").Funcs(template.FuncMap{"quote": quoteField}).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access log format: %w", err)
+	}
+	// Catches unknown fields and functions with invalid arguments at startup
+	if err := tmpl.Execute(io.Discard, SAMPLE_ACCESS_LOG_ENTRY); err != nil {
+		return nil, fmt.Errorf("invalid access log format: %w", err)
+	}
+	return &AccessLog{template: tmpl, out: out}, nil
+}
+
+// Creates the access log defined by the given configuration. Its file is
+// rotated like the log file.
+func NewAccessLogFromConfig(cfg *config.Config) (*AccessLog, error) {
+	file := cfg.AccessLog.File
+	if file == "" {
+		file = path.Join(cfg.CaptureDir, DEFAULT_ACCESS_LOG_FILE)
+	}
+	out, err := OpenRotatingFile(file, int64(cfg.Logging.Rotation.MaxSize)*1024*1024, cfg.Logging.Rotation.MaxBackups)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the access log file: %w", err)
+	}
+	ret, err := NewAccessLog(cfg.AccessLog.Format, out)
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Writes the entry.
+func (l *AccessLog) Log(entry *AccessLogEntry) error {
+	var buff bytes.Buffer
+	if err := l.template.Execute(&buff, entry); err != nil {
+		return err
+	}
+	buff.WriteByte('\n')
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err := l.out.Write(buff.Bytes())
+	return err
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+func newTestAccessLogEntry() *AccessLogEntry {
+	return &AccessLogEntry{
+		Time:       time.Date(2024, 3, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		Server:     "main",
+		RemoteHost: "127.0.0.1",
+		User:       "frank",
+		Method:     "GET",
+		URI:        "/apache_pb.gif?a=1",
+		Proto:      "HTTP/1.1",
+		UserAgent:  `Mozilla/4.08 "x"`,
+		Status:     200,
+		Bytes:      2326,
+		Duration:   1500 * time.Microsecond,
+		Rule:       "response-0",
+	}
+}
+
+func TestAccessLog_Formats(t *testing.T) {
+	for _, c := range []struct {
+		format   string
+		expected string
+	}{
+		{"common", `127.0.0.1 - frank [10/Mar/2024:13:55:36 -0700] "GET /apache_pb.gif?a=1 HTTP/1.1" 200 2326`},
+		{"", `127.0.0.1 - frank [10/Mar/2024:13:55:36 -0700] "GET /apache_pb.gif?a=1 HTTP/1.1" 200 2326 "-" "Mozilla/4.08 \"x\""`},
+		{"Combined", `127.0.0.1 - frank [10/Mar/2024:13:55:36 -0700] "GET /apache_pb.gif?a=1 HTTP/1.1" 200 2326 "-" "Mozilla/4.08 \"x\""`},
+		{"{{.Server}} {{.Status}} {{.Bytes}} {{.Duration}} {{.Rule}}", "main 200 2326 1.5ms response-0"},
+	} {
+		var out bytes.Buffer
+		l, err := NewAccessLog(c.format, &out)
+		require.Nil(t, err)
+		require.Nil(t, l.Log(newTestAccessLogEntry()))
+		assert.Equal(t, c.expected+"\n", out.String())
+	}
+
+	_, err := NewAccessLog("{{.Status", &bytes.Buffer{})
+	assert.ErrorContains(t, err, "invalid access log format")
+	_, err = NewAccessLog("{{.Unknown}}", &bytes.Buffer{})
+	assert.ErrorContains(t, err, "invalid access log format")
+	_, err = NewAccessLog("{{quote .Status}}", &bytes.Buffer{})
+	assert.ErrorContains(t, err, "invalid access log format")
+	_, err = NewAccessLog("{{.Time.Format \"2006\"}} {{quote .Method}}", &bytes.Buffer{})
+	assert.Nil(t, err)
+}
+
+func TestAccessLogEntry_CLFBytes(t *testing.T) {
+	entry := newTestAccessLogEntry()
+	entry.Bytes = 0
+	assert.Equal(t, "-", entry.CLFBytes())
+}
+
+func TestNewAccessLogEntry(t *testing.T) {
+	request := httptest.NewRequest("POST", "/a?b=1", nil)
+	request.RemoteAddr = "[::1]:1234"
+	request.SetBasicAuth("user", "pass")
+	request.Header.Set("Referer", "http://example.com/")
+	request.Header.Set("User-Agent", "test")
+	start := time.Now()
+	entry := newAccessLogEntry(request, start)
+	assert.Equal(t, start, entry.Time)
+	assert.Equal(t, "::1", entry.RemoteHost)
+	assert.Equal(t, "user", entry.User)
+	assert.Equal(t, "POST", entry.Method)
+	assert.Equal(t, "/a?b=1", entry.URI)
+	assert.Equal(t, "HTTP/1.1", entry.Proto)
+	assert.Equal(t, "example.com", entry.Host)
+	assert.Equal(t, "http://example.com/", entry.Referer)
+	assert.Equal(t, "test", entry.UserAgent)
+
+	request = httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "@"
+	entry = newAccessLogEntry(request, start)
+	assert.Equal(t, "-", entry.RemoteHost)
+	assert.Equal(t, "-", entry.User)
+}
+
+func TestEngine_AccessLog(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Name: "s1",
+		AccessLog: config.AccessLogConfig{
+			Enabled: true,
+			Format:  "{{.Server}} {{.Method}} {{.URI}} {{.Status}} {{.Bytes}} {{.Rule}}",
+		},
+		Admin: config.AdminConfig{Enabled: true, Prefix: "/_admin"},
+		Responses: []*config.ResponseConfig{
+			{ID: "a", PathPattern: "^/a$", ReturnCode: 201, Body: "MTIz"},
+		},
+	})
+	serve(e, "GET", "/a", "")
+	serve(e, "POST", "/b", "x")
+	serve(e, "GET", "/_admin/captures", "")
+
+	data, err := os.ReadFile(path.Join(e.Config.CaptureDir, DEFAULT_ACCESS_LOG_FILE))
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Equal(t, []string{
+		"s1 GET /a 201 3 a",
+		"s1 POST /b 200 2 default",
+	}, lines)
+}
+
+func TestNewAccessLogFromConfig(t *testing.T) {
+	cfg := &config.Config{CaptureDir: t.TempDir()}
+	cfg.AccessLog.File = path.Join(cfg.CaptureDir, "other.log")
+	cfg.AccessLog.Format = "{{.Method}}"
+	l, err := NewAccessLogFromConfig(cfg)
+	require.Nil(t, err)
+	require.Nil(t, l.Log(newTestAccessLogEntry()))
+	data, err := os.ReadFile(cfg.AccessLog.File)
+	require.Nil(t, err)
+	assert.Equal(t, "GET\n", string(data))
+
+	cfg.AccessLog.Format = "{{"
+	_, err = NewAccessLogFromConfig(cfg)
+	assert.ErrorContains(t, err, "invalid access log format")
+
+	cfg.AccessLog.File = path.Join(cfg.CaptureDir, "missing", "access.log")
+	_, err = NewAccessLogFromConfig(cfg)
+	assert.ErrorContains(t, err, "unable to open the access log file")
+}
@@ -0,0 +1,400 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+	"go.uber.org/zap"
+)
+
+// Files of the web UI.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// Handler of the admin API. Requests handled by it are never captured nor
+// matched against the responses.
+type adminHandler struct {
+	engine *Engine
+	prefix string
+	ui     http.Handler
+}
+
+/*
+Checks the path prefix of the admin API. It must start with "/" and must not be
+the root, otherwise every request would belong to the admin API.
+*/
+func CheckAdminPrefix(prefix string) error {
+	if !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("the admin prefix '%s' must start with '/'", prefix)
+	}
+	if strings.TrimRight(prefix, "/") == "" {
+		return fmt.Errorf("the admin prefix must not be the root")
+	}
+	return nil
+}
+
+func newAdminHandler(engine *Engine, prefix string) *adminHandler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return &adminHandler{
+		engine: engine,
+		prefix: prefix,
+		ui:     http.StripPrefix(prefix, http.FileServer(http.FS(uiFiles))),
+	}
+}
+
+// Returns true if the given path belongs to the admin API.
+func (h *adminHandler) Owns(path string) bool {
+	return path == h.prefix || strings.HasPrefix(path, h.prefix+"/")
+}
+
+func (h *adminHandler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	// A separate admin listener also exposes the metrics at the usual path
+	if request.URL.Path == "/metrics" && h.engine.Config.Admin.Address != "" && !h.Owns(request.URL.Path) {
+		h.metrics(response, request)
+		return
+	}
+	if !h.Owns(request.URL.Path) {
+		writeError(response, http.StatusNotFound, "not found")
+		return
+	}
+	route := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, h.prefix), "/"), "/")
+	switch {
+	case len(route) == 1 && route[0] == "":
+		http.Redirect(response, request, h.prefix+"/ui/", http.StatusFound)
+	case route[0] == "ui":
+		h.ui.ServeHTTP(response, request)
+	case len(route) == 1 && route[0] == "captures":
+		switch request.Method {
+		case http.MethodGet:
+			h.listCaptures(response, request)
+		case http.MethodDelete:
+			h.clearCaptures(response)
+		default:
+			writeMethodNotAllowed(response, http.MethodGet, http.MethodDelete)
+		}
+	case len(route) == 2 && route[0] == "captures" && route[1] == "count":
+		if request.Method != http.MethodGet {
+			writeMethodNotAllowed(response, http.MethodGet)
+			return
+		}
+		h.countCaptures(response, request)
+	case len(route) == 2 && route[0] == "captures":
+		switch request.Method {
+		case http.MethodGet:
+			h.getCapture(response, route[1])
+		case http.MethodDelete:
+			h.deleteCapture(response, route[1])
+		default:
+			writeMethodNotAllowed(response, http.MethodGet, http.MethodDelete)
+		}
+	case len(route) == 1 && route[0] == "metrics":
+		h.metrics(response, request)
+	case len(route) == 1 && route[0] == "verify":
+		if request.Method != http.MethodPost {
+			writeMethodNotAllowed(response, http.MethodPost)
+			return
+		}
+		h.verify(response, request)
+	case len(route) == 1 && route[0] == "rules":
+		switch request.Method {
+		case http.MethodGet:
+			h.listRules(response)
+		case http.MethodPost:
+			h.addRule(response, request)
+		case http.MethodDelete:
+			h.engine.Responses.SetResponses(nil)
+			response.WriteHeader(http.StatusNoContent)
+		default:
+			writeMethodNotAllowed(response, http.MethodGet, http.MethodPost, http.MethodDelete)
+		}
+	case len(route) == 2 && route[0] == "rules" && route[1] == "reset" && request.Method == http.MethodPost:
+		h.engine.ResetResponses()
+		h.listRules(response)
+	case len(route) == 2 && route[0] == "rules":
+		switch request.Method {
+		case http.MethodGet:
+			h.getRule(response, route[1])
+		case http.MethodPut:
+			h.replaceRule(response, request, route[1])
+		case http.MethodDelete:
+			if h.engine.Responses.RemoveResponse(route[1]) {
+				response.WriteHeader(http.StatusNoContent)
+			} else {
+				writeError(response, http.StatusNotFound, "rule not found")
+			}
+		default:
+			writeMethodNotAllowed(response, http.MethodGet, http.MethodPut, http.MethodDelete)
+		}
+	default:
+		writeError(response, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *adminHandler) listRules(response http.ResponseWriter) {
+	list := make([]*config.ResponseConfig, 0)
+	for _, r := range h.engine.Responses.Responses() {
+		list = append(list, ConfigFromResponse(r))
+	}
+	writeJSON(response, http.StatusOK, list)
+}
+
+func (h *adminHandler) getRule(response http.ResponseWriter, id string) {
+	r := h.engine.Responses.Get(id)
+	if r == nil {
+		writeError(response, http.StatusNotFound, "rule not found")
+		return
+	}
+	writeJSON(response, http.StatusOK, ConfigFromResponse(r))
+}
+
+// Reads a rule from the request body.
+func readRule(request *http.Request) (*config.ResponseConfig, error) {
+	cfg := new(config.ResponseConfig)
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (h *adminHandler) addRule(response http.ResponseWriter, request *http.Request) {
+	position := -1
+	if s := request.URL.Query().Get("position"); s != "" {
+		p, err := strconv.Atoi(s)
+		if err != nil || p < 0 {
+			writeError(response, http.StatusBadRequest, fmt.Sprintf("invalid position '%s'", s))
+			return
+		}
+		position = p
+	}
+	cfg, err := readRule(request)
+	if err != nil {
+		writeError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if cfg.ID == "" {
+		cfg.ID = h.engine.newRuleID()
+	}
+	r, err := NewResponseFromConfig(cfg)
+	if err != nil {
+		writeError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.engine.Responses.InsertResponse(position, r) {
+		writeError(response, http.StatusConflict, fmt.Sprintf("rule '%s' already exists", cfg.ID))
+		return
+	}
+	writeJSON(response, http.StatusCreated, ConfigFromResponse(r))
+}
+
+func (h *adminHandler) replaceRule(response http.ResponseWriter, request *http.Request, id string) {
+	cfg, err := readRule(request)
+	if err != nil {
+		writeError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if cfg.ID != "" && cfg.ID != id {
+		writeError(response, http.StatusBadRequest, "the rule id does not match the path")
+		return
+	}
+	cfg.ID = id
+	r, err := NewResponseFromConfig(cfg)
+	if err != nil {
+		writeError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.engine.Responses.ReplaceResponse(r) {
+		writeError(response, http.StatusNotFound, "rule not found")
+		return
+	}
+	writeJSON(response, http.StatusOK, ConfigFromResponse(r))
+}
+
+func (h *adminHandler) listCaptures(response http.ResponseWriter, request *http.Request) {
+	filter, err := ParseCaptureFilter(request.URL.Query())
+	if err != nil {
+		writeError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	list, err := h.engine.Captures.List(filter)
+	if err != nil {
+		h.internalError(response, err)
+		return
+	}
+	writeJSON(response, http.StatusOK, list)
+}
+
+func (h *adminHandler) countCaptures(response http.ResponseWriter, request *http.Request) {
+	filter, err := ParseCaptureFilter(request.URL.Query())
+	if err != nil {
+		writeError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	list, err := h.engine.Captures.List(filter)
+	if err != nil {
+		h.internalError(response, err)
+		return
+	}
+	writeJSON(response, http.StatusOK, map[string]int{"count": len(list)})
+}
+
+func (h *adminHandler) getCapture(response http.ResponseWriter, id string) {
+	r, err := h.engine.Captures.Get(id)
+	if errors.Is(err, capture.ErrNotFound) {
+		writeError(response, http.StatusNotFound, err.Error())
+	} else if err != nil {
+		h.internalError(response, err)
+	} else {
+		writeJSON(response, http.StatusOK, r)
+	}
+}
+
+func (h *adminHandler) deleteCapture(response http.ResponseWriter, id string) {
+	err := h.engine.Captures.Delete(id)
+	if errors.Is(err, capture.ErrNotFound) {
+		writeError(response, http.StatusNotFound, err.Error())
+	} else if err != nil {
+		h.internalError(response, err)
+	} else {
+		response.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *adminHandler) clearCaptures(response http.ResponseWriter) {
+	if err := h.engine.Captures.Clear(); err != nil {
+		h.internalError(response, err)
+	} else {
+		response.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *adminHandler) verify(response http.ResponseWriter, request *http.Request) {
+	criteria := new(capture.Criteria)
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(criteria); err != nil {
+		writeError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := criteria.Validate(); err != nil {
+		writeError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	result, err := h.engine.Verify(criteria)
+	if err != nil {
+		h.internalError(response, err)
+		return
+	}
+	writeJSON(response, http.StatusOK, result)
+}
+
+func (h *adminHandler) internalError(response http.ResponseWriter, err error) {
+	h.engine.Logger.Error("Admin API error.", zap.Error(err))
+	writeError(response, http.StatusInternalServerError, err.Error())
+}
+
+//------------------------------------------------------------------------------
+
+// Parses a capture filter from the query parameters method, path (regular
+// expression), host, rule, since, until (RFC 3339) and limit.
+func ParseCaptureFilter(query url.Values) (*capture.Filter, error) {
+	filter := &capture.Filter{
+		Method:   query.Get("method"),
+		Host:     query.Get("host"),
+		RuleID:   query.Get("rule"),
+		Listener: query.Get("listener"),
+	}
+	if s := query.Get("path"); s != "" {
+		p, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path: %w", err)
+		}
+		filter.PathPattern = p
+	}
+	if s := query.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since: %w", err)
+		}
+		filter.Since = t
+	}
+	if s := query.Get("until"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid until: %w", err)
+		}
+		filter.Until = t
+	}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit '%s'", s)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// Serves the metrics of the engine.
+func (h *adminHandler) metrics(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writeMethodNotAllowed(response, http.MethodGet, http.MethodHead)
+		return
+	}
+	h.engine.serveMetrics(response)
+}
+
+// Writes a value as JSON.
+func writeJSON(response http.ResponseWriter, code int, value any) {
+	response.Header().Set("Content-Type", DEFAULT_CONTENT_TYPE)
+	response.WriteHeader(code)
+	json.NewEncoder(response).Encode(value)
+}
+
+// Writes an error as a JSON object.
+func writeError(response http.ResponseWriter, code int, message string) {
+	writeJSON(response, code, map[string]string{"error": message})
+}
+
+func writeMethodNotAllowed(response http.ResponseWriter, allowed ...string) {
+	response.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(response, http.StatusMethodNotAllowed, "method not allowed")
+}
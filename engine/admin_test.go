@@ -0,0 +1,402 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+// Sends a request to the engine and returns the recorded response.
+func serve(e *Engine, method string, target string, body string) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	e.ServeHTTP(resp, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return resp
+}
+
+func TestCheckAdminPrefix(t *testing.T) {
+	assert.Nil(t, CheckAdminPrefix("/_admin"))
+	assert.Nil(t, CheckAdminPrefix("/_admin/"))
+	for _, prefix := range []string{"", "/", "//", "admin"} {
+		assert.NotNil(t, CheckAdminPrefix(prefix), prefix)
+	}
+
+	// The engine refuses to turn every path into the admin API
+	cfg := &config.Config{CaptureDir: t.TempDir(), Admin: config.AdminConfig{Enabled: true, Prefix: "/"}}
+	_, err := NewEngine(cfg)
+	assert.ErrorContains(t, err, "the admin prefix must not be the root")
+	cfg.Admin.Prefix = ""
+	_, err = NewEngine(cfg)
+	assert.ErrorContains(t, err, "must start with '/'")
+}
+
+func TestAdminHandler_Captures(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Admin: config.AdminConfig{Enabled: true, Prefix: "/_admin/"},
+	})
+
+	serve(e, "POST", "/a", "1")
+	serve(e, "GET", "/b", "")
+	serve(e, "GET", "/b/c", "")
+
+	// Admin requests are never captured
+	resp := serve(e, "GET", "/_admin/captures", "")
+	assert.Equal(t, 200, resp.Code)
+	var list []*capture.CapturedRequest
+	require.Nil(t, json.Unmarshal(resp.Body.Bytes(), &list))
+	require.Len(t, list, 3)
+
+	resp = serve(e, "GET", "/_admin/captures?method=GET&path=^/b", "")
+	assert.Equal(t, 200, resp.Code)
+	require.Nil(t, json.Unmarshal(resp.Body.Bytes(), &list))
+	require.Len(t, list, 2)
+
+	resp = serve(e, "GET", "/_admin/captures/count?path=^/b$", "")
+	assert.Equal(t, 200, resp.Code)
+	assert.JSONEq(t, `{"count":1}`, resp.Body.String())
+
+	resp = serve(e, "GET", "/_admin/captures/"+list[0].ID, "")
+	assert.Equal(t, 200, resp.Code)
+	var c capture.CapturedRequest
+	require.Nil(t, json.Unmarshal(resp.Body.Bytes(), &c))
+	assert.Equal(t, list[0].ID, c.ID)
+
+	resp = serve(e, "DELETE", "/_admin/captures/"+list[0].ID, "")
+	assert.Equal(t, 204, resp.Code)
+	resp = serve(e, "GET", "/_admin/captures/"+list[0].ID, "")
+	assert.Equal(t, 404, resp.Code)
+	resp = serve(e, "DELETE", "/_admin/captures/"+list[0].ID, "")
+	assert.Equal(t, 404, resp.Code)
+
+	resp = serve(e, "DELETE", "/_admin/captures", "")
+	assert.Equal(t, 204, resp.Code)
+	resp = serve(e, "GET", "/_admin/captures/count", "")
+	assert.JSONEq(t, `{"count":0}`, resp.Body.String())
+
+	resp = serve(e, "GET", "/_admin/captures?limit=x", "")
+	assert.Equal(t, 400, resp.Code)
+	resp = serve(e, "PUT", "/_admin/captures", "")
+	assert.Equal(t, 405, resp.Code)
+	assert.Equal(t, "GET, DELETE", resp.Header().Get("Allow"))
+	resp = serve(e, "GET", "/_admin/unknown", "")
+	assert.Equal(t, 404, resp.Code)
+	assert.Len(t, loadCaptures(t, e), 0)
+}
+
+func TestAdminHandler_SeparateListener(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Admin: config.AdminConfig{Enabled: true, Prefix: "/_admin", Address: "localhost:0"},
+	})
+
+	// The prefix is not reserved in the main listener
+	resp := serve(e, "GET", "/_admin/captures", "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "{}", resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	e.admin.ServeHTTP(resp, httptest.NewRequest("GET", "/_admin/captures/count", nil))
+	assert.JSONEq(t, `{"count":1}`, resp.Body.String())
+}
+
+func TestAdminHandler_UI(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Admin: config.AdminConfig{Enabled: true, Prefix: "/_admin"},
+	})
+
+	resp := serve(e, "GET", "/_admin", "")
+	assert.Equal(t, 302, resp.Code)
+	assert.Equal(t, "/_admin/ui/", resp.Header().Get("Location"))
+
+	resp = serve(e, "GET", "/_admin/ui/", "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "<title>dummy-http-server</title>")
+
+	resp = serve(e, "GET", "/_admin/ui/app.js", "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Header().Get("Content-Type"), "javascript")
+
+	resp = serve(e, "GET", "/_admin/ui/style.css", "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Len(t, loadCaptures(t, e), 0)
+}
+
+// Runs the toCurl() function of the web UI with node against the capture.
+func uiToCurl(t *testing.T, c *capture.CapturedRequest) string {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not available")
+	}
+	source, err := uiFiles.ReadFile("ui/app.js")
+	require.Nil(t, err)
+	data, err := json.Marshal(c)
+	require.Nil(t, err)
+	script := `
+const vm = require("vm");
+const stub = new Proxy(() => stub, { get: () => stub, set: () => true });
+const ctx = { atob, TextDecoder, URLSearchParams, console: { log() {} }, setInterval() {},
+  setTimeout() {}, fetch: () => Promise.reject(new Error()), document: stub };
+vm.createContext(ctx);
+vm.runInContext(process.env.APP_JS, ctx);
+process.stdout.write(vm.runInContext("toCurl(" + process.env.CAPTURE + ")", ctx));
+`
+	cmd := exec.Command(node, "-e", script)
+	cmd.Env = append(os.Environ(), "APP_JS="+string(source), "CAPTURE="+string(data))
+	out, err := cmd.Output()
+	require.Nil(t, err)
+	return string(out)
+}
+
+// Runs the curl command with a fake curl that saves its arguments and input.
+func runCurl(t *testing.T, command string) ([]string, []byte) {
+	dir := t.TempDir()
+	cmd := exec.Command("sh", "-c", `curl() { cat > body; printf '%s\n' "$@" > args; }; `+command)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.Nil(t, err, string(out))
+	args, err := os.ReadFile(path.Join(dir, "args"))
+	require.Nil(t, err)
+	body, err := os.ReadFile(path.Join(dir, "body"))
+	require.Nil(t, err)
+	return strings.Split(strings.TrimSpace(string(args)), "\n"), body
+}
+
+func TestUI_toCurl_DecodedGzip(t *testing.T) {
+	e := newTestEngine(t, &config.Config{DecodeBody: true})
+	for _, body := range [][]byte{[]byte("h\u00e9llo 'world'"), {0, 1, 'a', 0xff, '\n'}} {
+		require.Nil(t, e.Captures.Clear())
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		w.Write(body)
+		w.Close()
+		request := httptest.NewRequest("POST", "/a?b=1", bytes.NewReader(gz.Bytes()))
+		request.Header.Set("Content-Encoding", "gzip")
+		request.Header.Set("Content-Length", "1234")
+		request.Header.Set("X-Test", "1")
+		e.ServeHTTP(httptest.NewRecorder(), request)
+		caps := loadCaptures(t, e)
+		require.Len(t, caps, 1)
+		require.Nil(t, caps[0].EncodedBody)
+
+		command := uiToCurl(t, caps[0])
+		assert.NotContains(t, command, "Content-Encoding")
+		assert.NotContains(t, command, "Content-Length")
+		args, sent := runCurl(t, command)
+		assert.Contains(t, args, "X-Test: 1")
+		assert.Equal(t, "http://example.com/a?b=1", args[len(args)-1])
+		if bytes.IndexByte(body, 0) >= 0 {
+			assert.Contains(t, command, "| base64 -d | curl")
+			assert.Contains(t, args, "@-")
+			assert.Equal(t, body, sent)
+		} else {
+			assert.Contains(t, args, string(body))
+			assert.Empty(t, sent)
+		}
+	}
+}
+
+func TestUI_toCurl_EncodedBody(t *testing.T) {
+	e := newTestEngine(t, &config.Config{DecodeBody: true, KeepEncodedBody: true})
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("abc"))
+	w.Close()
+	request := httptest.NewRequest("POST", "/", bytes.NewReader(gz.Bytes()))
+	request.Header.Set("Content-Encoding", "gzip")
+	request.Header.Set("Content-Length", "1234")
+	e.ServeHTTP(httptest.NewRecorder(), request)
+	caps := loadCaptures(t, e)
+	require.Len(t, caps, 1)
+
+	// The encoded body is sent with its encoding
+	command := uiToCurl(t, caps[0])
+	assert.Contains(t, command, "Content-Encoding: gzip")
+	assert.NotContains(t, command, "Content-Length")
+	_, sent := runCurl(t, command)
+	assert.Equal(t, gz.Bytes(), sent)
+}
+
+func TestUI_toCurl_Method(t *testing.T) {
+	e := newTestEngine(t, &config.Config{})
+	// Any token is accepted as a method
+	method := "`id`|touch$IFS'x'&"
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/", nil))
+	caps := loadCaptures(t, e)
+	require.Len(t, caps, 1)
+	require.Equal(t, method, caps[0].Method)
+
+	args, _ := runCurl(t, uiToCurl(t, caps[0]))
+	assert.Equal(t, []string{"-X", method, "http://example.com/"}, args)
+}
+
+func TestAdminHandler_Rules(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Admin: config.AdminConfig{Enabled: true, Prefix: "/_admin"},
+		Responses: []*config.ResponseConfig{
+			{PathPattern: "^/a$", ReturnCode: 201},
+		},
+	})
+
+	resp := serve(e, "GET", "/_admin/rules", "")
+	assert.Equal(t, 200, resp.Code)
+	assert.JSONEq(t, `[{"id":"response-0","pathPattern":"^/a$","returnCode":201}]`, resp.Body.String())
+
+	// Add
+	resp = serve(e, "POST", "/_admin/rules?position=0", `{"pathPattern":"^/a$","returnCode":202}`)
+	assert.Equal(t, 201, resp.Code)
+	assert.JSONEq(t, `{"id":"runtime-1","pathPattern":"^/a$","returnCode":202}`, resp.Body.String())
+	assert.Equal(t, 202, serve(e, "GET", "/a", "").Code)
+
+	resp = serve(e, "POST", "/_admin/rules", `{"id":"b","pathPattern":"^/b$","returnCode":203}`)
+	assert.Equal(t, 201, resp.Code)
+	assert.Equal(t, 203, serve(e, "GET", "/b", "").Code)
+	resp = serve(e, "POST", "/_admin/rules", `{"id":"b"}`)
+	assert.Equal(t, 409, resp.Code)
+	resp = serve(e, "POST", "/_admin/rules", `{"pathPattern":"["}`)
+	assert.Equal(t, 400, resp.Code)
+	resp = serve(e, "POST", "/_admin/rules", `{"unknown":1}`)
+	assert.Equal(t, 400, resp.Code)
+	resp = serve(e, "POST", "/_admin/rules?position=x", `{}`)
+	assert.Equal(t, 400, resp.Code)
+
+	// The fingerprint is returned in the format of the captures
+	resp = serve(e, "POST", "/_admin/rules", `{"id":"cert","clientCertFingerprint":"`+strings.Repeat("ab", 32)+`"}`)
+	assert.Equal(t, 201, resp.Code)
+	assert.JSONEq(t, `{"id":"cert","clientCertFingerprint":"`+strings.Repeat("AB:", 31)+`AB","returnCode":200}`, resp.Body.String())
+	assert.Equal(t, 204, serve(e, "DELETE", "/_admin/rules/cert", "").Code)
+
+	// Get and replace
+	resp = serve(e, "GET", "/_admin/rules/b", "")
+	assert.Equal(t, 200, resp.Code)
+	assert.JSONEq(t, `{"id":"b","pathPattern":"^/b$","returnCode":203}`, resp.Body.String())
+	resp = serve(e, "PUT", "/_admin/rules/b", `{"pathPattern":"^/b$","returnCode":204}`)
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, 204, serve(e, "GET", "/b", "").Code)
+	resp = serve(e, "PUT", "/_admin/rules/b", `{"id":"c"}`)
+	assert.Equal(t, 400, resp.Code)
+	resp = serve(e, "PUT", "/_admin/rules/c", `{}`)
+	assert.Equal(t, 404, resp.Code)
+	resp = serve(e, "GET", "/_admin/rules/c", "")
+	assert.Equal(t, 404, resp.Code)
+
+	// Delete
+	resp = serve(e, "DELETE", "/_admin/rules/b", "")
+	assert.Equal(t, 204, resp.Code)
+	resp = serve(e, "DELETE", "/_admin/rules/b", "")
+	assert.Equal(t, 404, resp.Code)
+	assert.Equal(t, 200, serve(e, "GET", "/b", "").Code)
+
+	// Reset
+	resp = serve(e, "POST", "/_admin/rules/reset", "")
+	assert.Equal(t, 200, resp.Code)
+	assert.JSONEq(t, `[{"id":"response-0","pathPattern":"^/a$","returnCode":201}]`, resp.Body.String())
+	assert.Equal(t, 201, serve(e, "GET", "/a", "").Code)
+
+	resp = serve(e, "DELETE", "/_admin/rules", "")
+	assert.Equal(t, 204, resp.Code)
+	assert.Equal(t, 200, serve(e, "GET", "/a", "").Code)
+}
+
+func TestAdminHandler_Verify(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Admin: config.AdminConfig{Enabled: true, Prefix: "/_admin"},
+	})
+	serve(e, "POST", "/orders", "1")
+	serve(e, "POST", "/orders", "2")
+	serve(e, "GET", "/orders", "")
+
+	resp := serve(e, "POST", "/_admin/verify", `{"method":"POST","path":"^/orders$","count":2}`)
+	assert.Equal(t, 200, resp.Code)
+	var result capture.VerifyResult
+	require.Nil(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	assert.True(t, result.Matched)
+	assert.Equal(t, 2, result.Count)
+	assert.Len(t, result.Matches, 2)
+
+	resp = serve(e, "POST", "/_admin/verify", `{"method":"PUT"}`)
+	assert.Equal(t, 200, resp.Code)
+	result = capture.VerifyResult{}
+	require.Nil(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	assert.False(t, result.Matched)
+	assert.Len(t, result.NearMisses, 3)
+
+	resp = serve(e, "POST", "/_admin/verify", `{"path":"["}`)
+	assert.Equal(t, 400, resp.Code)
+	resp = serve(e, "POST", "/_admin/verify", `{"x":1}`)
+	assert.Equal(t, 400, resp.Code)
+	resp = serve(e, "GET", "/_admin/verify", "")
+	assert.Equal(t, 405, resp.Code)
+}
+
+func TestParseCaptureFilter(t *testing.T) {
+	f, err := ParseCaptureFilter(url.Values{})
+	require.Nil(t, err)
+	assert.Equal(t, &capture.Filter{}, f)
+
+	f, err = ParseCaptureFilter(url.Values{
+		"method":   {"GET"},
+		"path":     {"^/a$"},
+		"host":     {"host1"},
+		"rule":     {"r1"},
+		"listener": {"l1"},
+		"since":    {"2024-01-02T03:04:05Z"},
+		"until":    {"2024-01-02T03:04:06.5Z"},
+		"limit":    {"10"},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "GET", f.Method)
+	assert.Equal(t, "^/a$", f.PathPattern.String())
+	assert.Equal(t, "host1", f.Host)
+	assert.Equal(t, "r1", f.RuleID)
+	assert.Equal(t, "l1", f.Listener)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), f.Since)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 6, 500000000, time.UTC), f.Until)
+	assert.Equal(t, 10, f.Limit)
+
+	for _, q := range []url.Values{
+		{"path": {"["}},
+		{"since": {"x"}},
+		{"until": {"x"}},
+		{"limit": {"-1"}},
+	} {
+		_, err = ParseCaptureFilter(q)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+const (
+	// Name of the automatic CA certificate file.
+	AUTO_CA_CERT_FILE = "dummy-ca.pem"
+	// Name of the automatic CA private key file.
+	AUTO_CA_KEY_FILE = "dummy-ca-key.pem"
+	// Name of the automatic server certificate file.
+	AUTO_CERT_FILE = "dummy-server.pem"
+	// Name of the automatic server private key file.
+	AUTO_KEY_FILE = "dummy-server-key.pem"
+	// Validity of the automatic CA.
+	AUTO_CA_VALIDITY = 10 * 365 * 24 * time.Hour
+	// Validity of the automatic server certificate.
+	AUTO_CERT_VALIDITY = 397 * 24 * time.Hour
+)
+
+// The files of the automatic certificates.
+type AutoCertificates struct {
+	CACertFile string
+	CAKeyFile  string
+	CertFile   string
+	KeyFile    string
+	// SHA-256 fingerprint of the CA certificate.
+	CAFingerprint string
+}
+
+/*
+Returns the directory of the automatic certificates. It defaults to the capture
+directory.
+*/
+func AutoCertDir(cfg *config.Config) string {
+	if cfg.TLS.AutoDir != "" {
+		return cfg.TLS.AutoDir
+	}
+	return cfg.CaptureDir
+}
+
+/*
+Returns the subject alternative names of the automatic server certificate. If
+none is configured, it defaults to localhost, the loopback addresses and the
+host of the binding address.
+*/
+func AutoCertHosts(cfg *config.Config) []string {
+	if len(cfg.TLS.Hosts) > 0 {
+		return cfg.TLS.Hosts
+	}
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	host, _, err := net.SplitHostPort(cfg.Address)
+	if err == nil && host != "" && host != "0.0.0.0" && host != "::" && host != "localhost" {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// Returns the SHA-256 fingerprint of a certificate as colon separated hex.
+func Fingerprint(cert *x509.Certificate) string {
+	return capture.NewCertificateInfo(cert).SHA256
+}
+
+// Loads a certificate and its key from PEM files.
+func loadCertificate(certFile string, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, nil, fmt.Errorf("'%s' is not a PEM certificate", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("'%s' is not a PEM private key", keyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("'%s' is not an ECDSA private key", keyFile)
+	}
+	return cert, ecKey, nil
+}
+
+// Writes a certificate and its key as PEM files.
+func writeCertificate(der []byte, key *ecdsa.PrivateKey, certFile string, keyFile string) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// Creates a new random serial number.
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// Creates a new CA certificate and its key.
+func newAutoCA(certFile string, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "dummy-http-server local CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(AUTO_CA_VALIDITY),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeCertificate(der, key, certFile, keyFile); err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// Creates a new server certificate signed by the CA.
+func newAutoCertificate(ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string,
+	certFile string, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(AUTO_CERT_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return writeCertificate(der, key, certFile, keyFile)
+}
+
+// Returns true if the certificate is usable for all hosts for at least one day.
+func isCertificateUsable(cert *x509.Certificate, ca *x509.Certificate, hosts []string) bool {
+	if time.Now().Add(24 * time.Hour).After(cert.NotAfter) {
+		return false
+	}
+	if cert.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Makes sure that the automatic CA and server certificate exist inside dir. The
+existing files are reused; the CA is created only if it does not exist and the
+server certificate is recreated if it is missing, about to expire, not issued by
+the CA or not valid for all hosts.
+*/
+func EnsureAutoCertificates(dir string, hosts []string) (*AutoCertificates, error) {
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("at least one host is required")
+	}
+	ret := &AutoCertificates{
+		CACertFile: path.Join(dir, AUTO_CA_CERT_FILE),
+		CAKeyFile:  path.Join(dir, AUTO_CA_KEY_FILE),
+		CertFile:   path.Join(dir, AUTO_CERT_FILE),
+		KeyFile:    path.Join(dir, AUTO_KEY_FILE),
+	}
+	// CA
+	ca, caKey, err := loadCertificate(ret.CACertFile, ret.CAKeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		ca, caKey, err = newAutoCA(ret.CACertFile, ret.CAKeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret.CAFingerprint = Fingerprint(ca)
+	// Server
+	cert, _, err := loadCertificate(ret.CertFile, ret.KeyFile)
+	if err == nil && isCertificateUsable(cert, ca, hosts) {
+		return ret, nil
+	}
+	if err := newAutoCertificate(ca, caKey, hosts, ret.CertFile, ret.KeyFile); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
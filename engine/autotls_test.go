@@ -0,0 +1,153 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+func TestAutoCertDir(t *testing.T) {
+	cfg := &config.Config{CaptureDir: "var"}
+	assert.Equal(t, "var", AutoCertDir(cfg))
+	cfg.TLS.AutoDir = "certs"
+	assert.Equal(t, "certs", AutoCertDir(cfg))
+}
+
+func TestAutoCertHosts(t *testing.T) {
+	cfg := &config.Config{Address: ":8080"}
+	assert.Equal(t, []string{"localhost", "127.0.0.1", "::1"}, AutoCertHosts(cfg))
+	cfg.Address = "0.0.0.0:8080"
+	assert.Equal(t, []string{"localhost", "127.0.0.1", "::1"}, AutoCertHosts(cfg))
+	cfg.Address = "192.168.0.1:8080"
+	assert.Equal(t, []string{"localhost", "127.0.0.1", "::1", "192.168.0.1"}, AutoCertHosts(cfg))
+	cfg.TLS.Hosts = []string{"api.local"}
+	assert.Equal(t, []string{"api.local"}, AutoCertHosts(cfg))
+}
+
+func TestEnsureAutoCertificates(t *testing.T) {
+	dir := t.TempDir()
+	auto, err := EnsureAutoCertificates(dir, []string{"localhost", "127.0.0.1"})
+	require.Nil(t, err)
+	assert.Equal(t, path.Join(dir, AUTO_CA_CERT_FILE), auto.CACertFile)
+	assert.Equal(t, path.Join(dir, AUTO_CA_KEY_FILE), auto.CAKeyFile)
+	assert.Equal(t, path.Join(dir, AUTO_CERT_FILE), auto.CertFile)
+	assert.Equal(t, path.Join(dir, AUTO_KEY_FILE), auto.KeyFile)
+	assert.Len(t, auto.CAFingerprint, 32*3-1)
+
+	ca, _, err := loadCertificate(auto.CACertFile, auto.CAKeyFile)
+	require.Nil(t, err)
+	assert.True(t, ca.IsCA)
+	assert.Equal(t, Fingerprint(ca), auto.CAFingerprint)
+	cert, _, err := loadCertificate(auto.CertFile, auto.KeyFile)
+	require.Nil(t, err)
+	assert.True(t, isCertificateUsable(cert, ca, []string{"localhost", "127.0.0.1"}))
+	assert.False(t, isCertificateUsable(cert, ca, []string{"api.local"}))
+	server, err := os.ReadFile(auto.CertFile)
+	require.Nil(t, err)
+
+	// Reused
+	again, err := EnsureAutoCertificates(dir, []string{"localhost"})
+	require.Nil(t, err)
+	assert.Equal(t, auto.CAFingerprint, again.CAFingerprint)
+	current, err := os.ReadFile(auto.CertFile)
+	require.Nil(t, err)
+	assert.Equal(t, server, current)
+
+	// New hosts keep the CA
+	again, err = EnsureAutoCertificates(dir, []string{"api.local"})
+	require.Nil(t, err)
+	assert.Equal(t, auto.CAFingerprint, again.CAFingerprint)
+	cert, _, err = loadCertificate(auto.CertFile, auto.KeyFile)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"api.local"}, cert.DNSNames)
+	assert.Nil(t, cert.CheckSignatureFrom(ca))
+
+	_, err = EnsureAutoCertificates(dir, nil)
+	assert.ErrorContains(t, err, "at least one host is required")
+	require.Nil(t, os.WriteFile(auto.CACertFile, []byte("x"), 0644))
+	_, err = EnsureAutoCertificates(dir, []string{"localhost"})
+	assert.ErrorContains(t, err, "is not a PEM certificate")
+}
+
+func TestExportAutoCA(t *testing.T) {
+	dir := t.TempDir()
+	configFile := path.Join(dir, "config.yaml")
+	require.Nil(t, os.WriteFile(configFile, []byte("captureDir: "+dir+"\n"), 0644))
+
+	var pemOut bytes.Buffer
+	auto, err := ExportAutoCA(configFile, "pem", &pemOut)
+	require.Nil(t, err)
+	data, err := os.ReadFile(auto.CACertFile)
+	require.Nil(t, err)
+	assert.Equal(t, data, pemOut.Bytes())
+
+	var derOut bytes.Buffer
+	_, err = ExportAutoCA(configFile, "DER", &derOut)
+	require.Nil(t, err)
+	ca, err := x509.ParseCertificate(derOut.Bytes())
+	require.Nil(t, err)
+	assert.Equal(t, auto.CAFingerprint, Fingerprint(ca))
+
+	_, err = ExportAutoCA(configFile, "p12", &derOut)
+	assert.ErrorContains(t, err, "invalid certificate format 'p12'")
+}
+
+func TestEngine_ServeHTTPSAuto(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		TLS: config.TLSConfig{Enabled: true, Auto: true},
+	})
+	require.NotNil(t, e.AutoCertificates)
+	assert.Equal(t, e.Config.CaptureDir, path.Dir(e.AutoCertificates.CACertFile))
+
+	srv := httptest.NewUnstartedServer(e)
+	srv.TLS = e.tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	pem, err := os.ReadFile(e.AutoCertificates.CACertFile)
+	require.Nil(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(pem))
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"},
+	}}
+	resp, err := client.Get(srv.URL + "/a")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+}
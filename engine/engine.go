@@ -28,11 +28,16 @@ package engine
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"path"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
@@ -44,15 +49,115 @@ type Engine struct {
 	Responses ResponseSet
 	Config    *config.Config
 	Logger    *zap.Logger
+	// Destination of the captured requests.
+	Captures capture.Store
+	// Default body overflow policy.
+	overflow OverflowPolicy
+	// Virtual hosts. They are replaced as a whole when the configuration changes.
+	vhosts atomic.Pointer[[]*VirtualHost]
+	// Policy for requests that match no virtual host.
+	unknownHost UnknownHostPolicy
+	// Request counters.
+	stats engineStats
+	// Requests being handled. Used to wait for them on shutdown.
+	handlers sync.WaitGroup
+	// Request metrics.
+	metrics *Metrics
+	// Access log. It is nil if the access log is disabled.
+	accessLog *AccessLog
+	// Admin API handler. It is nil if the admin API is disabled.
+	admin *adminHandler
+	// TLS configuration of the main listener. It is nil if TLS is disabled.
+	tlsConfig *tls.Config
+	// The automatic certificates. It is nil if they are not used.
+	AutoCertificates *AutoCertificates
+	// Sequence used to create the IDs of the rules added at runtime.
+	ruleSeq atomic.Int64
+	// Protects the responses of Config and reloadError.
+	configMutex sync.Mutex
+	// Error of the last configuration reload.
+	reloadError error
 }
 
-func NewEngine(config *config.Config) (*Engine, error) {
+// Option used to customize a new Engine.
+type EngineOption func(e *Engine)
+
+// Sets the logger of the engine. By default, the engine creates the logger
+// defined by the logging settings of its configuration.
+func WithLogger(logger *zap.Logger) EngineOption {
+	return func(e *Engine) {
+		e.Logger = logger
+	}
+}
+
+// Sets the access log of the engine. By default, the engine creates the access
+// log defined by its configuration if it is enabled.
+func WithAccessLog(accessLog *AccessLog) EngineOption {
+	return func(e *Engine) {
+		e.accessLog = accessLog
+	}
+}
+
+// Sets the destination of the captured requests. By default, the engine saves
+// them inside the capture directory. Sidecar files are only created when the
+// destination is a capture.DirStore.
+func WithStore(store capture.Store) EngineOption {
+	return func(e *Engine) {
+		e.Captures = store
+	}
+}
+
+func NewEngine(config *config.Config, options ...EngineOption) (*Engine, error) {
 	ret := &Engine{
-		Config: config,
+		Config:  config,
+		metrics: newMetrics(),
+	}
+	for _, option := range options {
+		option(ret)
 	}
-	if err := ret.initLogger(); err != nil {
+	overflow, err := ParseOverflowPolicy(config.BodyOverflow)
+	if err != nil {
 		return nil, err
 	}
+	if overflow == OVERFLOW_INHERIT {
+		overflow = OVERFLOW_TRUNCATE
+	}
+	ret.overflow = overflow
+	if ret.unknownHost, err = ParseUnknownHostPolicy(config.UnknownHost); err != nil {
+		return nil, err
+	}
+	tlsConfig := config.TLS
+	if tlsConfig.Enabled && tlsConfig.Auto {
+		auto, err := EnsureAutoCertificates(AutoCertDir(config), AutoCertHosts(config))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CertFile = auto.CertFile
+		tlsConfig.KeyFile = auto.KeyFile
+		ret.AutoCertificates = auto
+	}
+	if ret.tlsConfig, err = NewTLSConfig(&tlsConfig); err != nil {
+		return nil, err
+	}
+	if ret.Captures == nil {
+		ret.Captures = &capture.DirStore{Dir: config.CaptureDir}
+	}
+	if config.Admin.Enabled {
+		if err := CheckAdminPrefix(config.Admin.Prefix); err != nil {
+			return nil, err
+		}
+		ret.admin = newAdminHandler(ret, config.Admin.Prefix)
+	}
+	if ret.Logger == nil {
+		if err := ret.initLogger(); err != nil {
+			return nil, err
+		}
+	}
+	if ret.accessLog == nil && config.AccessLog.Enabled {
+		if ret.accessLog, err = NewAccessLogFromConfig(config); err != nil {
+			return nil, err
+		}
+	}
 	if err := ret.initResponses(); err != nil {
 		return nil, err
 	}
@@ -60,48 +165,218 @@ func NewEngine(config *config.Config) (*Engine, error) {
 }
 
 func (e *Engine) initLogger() error {
-	// Configure
-	logFile := path.Join(e.Config.CaptureDir, "log.log")
-	cfg := zap.NewProductionConfig()
-	cfg.ErrorOutputPaths = []string{logFile}
-	cfg.OutputPaths = []string{logFile}
-	// Build
-	logger, err := cfg.Build()
+	logger, err := NewLogger(e.Config)
 	if err != nil {
-		return nil
+		return err
 	}
 	e.Logger = logger
 	return nil
 }
 
 func (e *Engine) initResponses() error {
-	for i, cfg := range e.Config.Responses {
-		r, err := NewResponseFromConfig(cfg)
-		if err != nil {
-			e.Logger.Error("Bad response definition.", zap.Int("index", i), zap.Error(err))
-		} else {
-			e.Responses.AddResponse(r)
+	e.ResetResponses()
+	return nil
+}
+
+// Returns a new unique ID for a rule added at runtime.
+func (e *Engine) newRuleID() string {
+	for {
+		id := fmt.Sprintf("runtime-%d", e.ruleSeq.Add(1))
+		if e.Responses.Get(id) == nil {
+			return id
 		}
 	}
+}
+
+// Replaces the current responses with the ones defined by the configuration.
+// Bad response definitions are logged and skipped.
+func (e *Engine) ResetResponses() {
+	e.configMutex.Lock()
+	defer e.configMutex.Unlock()
+	responses, errs := NewResponsesFromConfig(e.Config.Responses)
+	for _, err := range errs {
+		e.Logger.Error("Bad response definition.", zap.Int("index", err.Index), zap.Error(err.Err))
+	}
+	e.Responses.SetResponses(responses)
+	vhosts, err := NewVirtualHostsFromConfig(e.Config.VirtualHosts)
+	if err != nil {
+		e.Logger.Error("Bad virtual host definition.", zap.Error(err))
+	}
+	e.vhosts.Store(&vhosts)
+}
+
+// Returns the current virtual hosts.
+func (e *Engine) VirtualHosts() []*VirtualHost {
+	if vhosts := e.vhosts.Load(); vhosts != nil {
+		return *vhosts
+	}
 	return nil
 }
 
+// Response selected for a request.
+type selection struct {
+	response Response
+	// The matched virtual host, if any.
+	vhost *VirtualHost
+	// True if virtual hosts are defined but none matches the host.
+	unknownHost bool
+	// Body overflow policy of the response, with OVERFLOW_INHERIT resolved.
+	overflow OverflowPolicy
+}
+
+/*
+Selects the response for the request. If virtual hosts are defined, the response
+is selected from the virtual host that matches the request host; requests to
+unknown hosts follow the unknown host policy.
+
+If match is not nil, it replaces Response.MatchRequest() and is called for every
+rule checked, in order, even after the first one that matches.
+*/
+func (e *Engine) selectResponse(request *http.Request, match func(Response) bool) selection {
+	find := func(responses *ResponseSet) Response {
+		if match == nil {
+			return responses.FindRequest(request)
+		}
+		var selected Response
+		for _, r := range responses.snapshot() {
+			if match(r) && selected == nil {
+				selected = r
+			}
+		}
+		if selected == nil {
+			return DEFAULT_RESPONSE
+		}
+		return selected
+	}
+
+	var ret selection
+	if vhosts := e.VirtualHosts(); len(vhosts) == 0 {
+		ret.response = find(&e.Responses)
+	} else if ret.vhost = FindVirtualHost(vhosts, RequestHost(request)); ret.vhost != nil {
+		ret.response = find(&ret.vhost.Responses)
+	} else {
+		ret.unknownHost = true
+		switch e.unknownHost {
+		case UNKNOWN_HOST_DEFAULT:
+			ret.response = DEFAULT_RESPONSE
+		case UNKNOWN_HOST_REJECT:
+			ret.response = MISDIRECTED_RESPONSE
+		default:
+			ret.response = find(&e.Responses)
+		}
+	}
+	ret.overflow = ret.response.OverflowPolicy()
+	if ret.overflow == OVERFLOW_INHERIT {
+		ret.overflow = e.overflow
+	}
+	return ret
+}
+
+// Returns the directory of the sidecar files or "" if the captures are not
+// saved into a directory.
+func (e *Engine) sidecarDir() string {
+	if store, ok := e.Captures.(*capture.DirStore); ok {
+		return store.Dir
+	}
+	return ""
+}
+
+// Verifies the captured requests against the given criteria. See capture.Verify()
+// for further details.
+func (e *Engine) Verify(criteria *capture.Criteria) (*capture.VerifyResult, error) {
+	return capture.Verify(e.Captures, criteria, capture.DEFAULT_MAX_NEAR_MISSES)
+}
+
 func (e *Engine) ServeHTTP(response http.ResponseWriter, request *http.Request) {
 
-	// Select the response first
-	method := request.Method
-	path := request.URL.Path
-	resp := e.Responses.Find(method, path)
+	// The health endpoints are never captured
+	if e.serveHealth(response, request) {
+		return
+	}
+	// The admin API is never captured
+	if e.admin != nil && e.Config.Admin.Address == "" && e.admin.Owns(request.URL.Path) {
+		e.admin.ServeHTTP(response, request)
+		return
+	}
+
+	e.stats.requests.Add(1)
+	e.handlers.Add(1)
+	defer e.handlers.Done()
+	e.stats.inFlight.Add(1)
+	defer e.stats.inFlight.Add(-1)
+	start := time.Now()
+	recorder := newResponseRecorder(response)
+	resp, bodySize := e.handle(recorder, request)
+	duration := time.Since(start)
+	e.metrics.observe(request.Method, recorder.Status(), resp.ID(), duration, bodySize, recorder.size)
+	if e.accessLog != nil {
+		entry := newAccessLogEntry(request, start)
+		entry.Server = e.Config.Name
+		entry.Status = recorder.Status()
+		entry.Bytes = recorder.size
+		entry.Duration = duration
+		entry.Rule = resp.ID()
+		if err := e.accessLog.Log(entry); err != nil {
+			e.Logger.Warn("Unable to write the access log.", zap.Error(err))
+		}
+	}
+}
+
+/*
+Handles a request that does not belong to the admin API. Returns the selected
+response and the number of body bytes read.
+*/
+func (e *Engine) handle(response http.ResponseWriter, request *http.Request) (Response, int64) {
+
+	// Select the response and its body overflow policy first
+	sel := e.selectResponse(request, nil)
+	resp, vhost, overflow := sel.response, sel.vhost, sel.overflow
 
 	// Capture the request
+	rejected := false
+	var bodySize int64
 	cap, err := capture.NewFromRequest(request, int64(e.Config.MaxRequestSize))
 	if err != nil {
 		e.Logger.Error("Unable to capture the request.", zap.Error(err))
+		e.stats.captureErrors.Add(1)
+		drainBody(request)
 	} else {
+		cap.RuleID = resp.ID()
+		cap.Listener = e.Config.Name
+		if vhost != nil {
+			cap.VirtualHost = vhost.Name
+		}
+		cap.Peer = peerCredentialsFromContext(request.Context())
+		if cap.Truncated {
+			rejected = overflow == OVERFLOW_REJECT
+			if overflow == OVERFLOW_SPOOL && !resp.SkipCapture() && e.sidecarDir() != "" {
+				if err := cap.SpoolTo(e.sidecarDir(), request.Body); err != nil {
+					e.Logger.Error("Unable to spool the request body.", zap.Error(err))
+				}
+			}
+		}
+		// Keep-alive connections require the whole body to be read
+		if err := cap.Drain(request.Body); err != nil {
+			e.Logger.Error("Unable to drain the request body.", zap.Error(err))
+		}
+		cap.SetTrailers(request.Trailer)
+		bodySize = cap.BodySize
+		if e.Config.DecodeBody {
+			if err := cap.DecodeBody(e.sidecarDir(), int64(e.Config.MaxRequestSize),
+				e.Config.KeepEncodedBody); err != nil {
+				e.Logger.Warn("Unable to decode the request body.", zap.String("id", cap.ID), zap.Error(err))
+			}
+		}
 		if !resp.SkipCapture() {
-			err := cap.SaveTo(e.Config.CaptureDir)
+			if err := cap.ParseForm(e.sidecarDir()); err != nil {
+				e.Logger.Warn("Unable to decode the form.", zap.String("id", cap.ID), zap.Error(err))
+			}
+			err := e.Captures.Save(&cap)
 			if err != nil {
 				e.Logger.Error("Unable to save the captured request.", zap.Error(err))
+				e.stats.captureErrors.Add(1)
+			} else {
+				e.stats.captures.Add(1)
 			}
 		} else {
 			e.Logger.Info("Capture skipped.", zap.String("URL", request.URL.String()),
@@ -110,46 +385,170 @@ func (e *Engine) ServeHTTP(response http.ResponseWriter, request *http.Request)
 	}
 
 	// Send the response
+	if rejected {
+		http.Error(response, http.StatusText(http.StatusRequestEntityTooLarge),
+			http.StatusRequestEntityTooLarge)
+		return resp, bodySize
+	}
 	err = WriteResponse(resp, response)
 	if err != nil {
 		e.Logger.Error("Unable to send the response.", zap.Error(err))
+		e.stats.responseErrors.Add(1)
 	}
+	return resp, bodySize
 }
 
-func (e *Engine) StartServer() error {
-	// Configure the server
-	srv := &http.Server{
-		Addr:           e.Config.Address,
-		Handler:        e,
+// Discards the remaining of the request body.
+func drainBody(request *http.Request) {
+	io.Copy(io.Discard, request.Body)
+}
+
+// Configures the TLS of the main server. HTTP/2 is disabled if the server only
+// accepts HTTP/1.1.
+func (e *Engine) configureTLS(server *http.Server) {
+	server.TLSConfig = e.tlsConfig
+	if e.tlsConfig != nil && e.Config.TLS.HTTP1Only {
+		// A non-nil empty map disables the automatic HTTP/2 support
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+}
+
+// Creates a new http.Server with the configured timeouts.
+func (e *Engine) newServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           address,
+		Handler:        handler,
 		ReadTimeout:    time.Duration(e.Config.ReadTimeout) * time.Second,
 		WriteTimeout:   time.Duration(e.Config.WriteTimeout) * time.Second,
 		MaxHeaderBytes: 0,
+		ConnContext:    connContext,
+	}
+}
+
+// Creates the http.Servers of this engine: the main one and, if configured,
+// the admin listener.
+func (e *Engine) servers() []*http.Server {
+	main := e.newServer(e.Config.Address, e)
+	e.configureTLS(main)
+	servers := []*http.Server{main}
+	if e.admin != nil && e.Config.Admin.Address != "" {
+		servers = append(servers, e.newServer(e.Config.Admin.Address, e.admin))
+	}
+	return servers
+}
+
+// Starts the server and waits until it receives a termination signal or fails.
+func (e *Engine) StartServer() error {
+	return StartServers(e)
+}
+
+/*
+Starts the servers of all engines in the same process and waits until SIGINT,
+SIGTERM or SIGQUIT is received or one of them fails. All servers are stopped
+together as described by Shutdown(). The logger and the configuration of the
+first engine are shared by all of them.
+*/
+func StartServers(engines ...*Engine) error {
+	e := engines[0]
+	var servers []*http.Server
+	var listeners []net.Listener
+	for _, engine := range engines {
+		for _, srv := range engine.servers() {
+			l, err := engine.listen(srv.Addr)
+			if err != nil {
+				for _, l := range listeners {
+					l.Close()
+				}
+				e.Logger.Error("Unable to start the server.", zap.String("address", srv.Addr), zap.Error(err))
+				return err
+			}
+			servers = append(servers, srv)
+			listeners = append(listeners, l)
+		}
 	}
 
-	// Wait for the kill signal
-	idleConnsClosed := make(chan struct{})
+	// Reload the configuration on SIGHUP or when the file changes
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
 	go func() {
-		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, os.Interrupt)
-		<-sigint
-		e.Logger.Info("Stopping the server...")
-		if err := srv.Shutdown(context.Background()); err != nil {
-			// Error from closing listeners, or context timeout:
-			log.Printf("HTTP server Shutdown: %v", err)
-		}
-		close(idleConnsClosed)
+		for range sighup {
+			e.Logger.Info("SIGHUP received.")
+			reloadAll(engines)
+		}
 	}()
+	if e.Config.WatchConfig {
+		watchConfig(engines)
+	}
 
-	// Start the server
-	e.Logger.Info("Server stopped.")
-	err := srv.ListenAndServe()
-	if err != http.ErrServerClosed {
-		e.Logger.Error("Erro", zap.Error(err))
-		close(idleConnsClosed)
-	} else {
-		err = nil
+	// Start the servers
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(stop)
+	errs := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func(srv *http.Server, l net.Listener) {
+			if srv.TLSConfig != nil {
+				// The certificates are already in TLSConfig
+				errs <- srv.ServeTLS(l, "", "")
+			} else {
+				errs <- srv.Serve(l)
+			}
+		}(srv, listeners[i])
 	}
-	<-idleConnsClosed
+	e.Logger.Info("Server started.")
+
+	// Wait for the kill signal or a server failure
+	var err error
+	select {
+	case sig := <-stop:
+		e.Logger.Info("Stopping the server...", zap.String("signal", sig.String()))
+	case err = <-errs:
+		e.Logger.Error("Unable to start the server.", zap.Error(err))
+	}
+	Shutdown(time.Duration(e.Config.ShutdownTimeout)*time.Second, servers, engines)
 	e.Logger.Info("Server stopped.")
 	return err
 }
+
+/*
+Time given to the requests still being handled to save their captures after the
+shutdown timeout expires and their connections are closed.
+*/
+var CAPTURE_FLUSH_TIMEOUT = 2 * time.Second
+
+/*
+Stops the servers gracefully. The servers stop accepting new connections and
+the requests being handled have until the timeout to finish; after that, the
+remaining connections are closed. The requests still being handled then have up
+to CAPTURE_FLUSH_TIMEOUT to save their captures. Finally, it logs the summary of
+each engine. All servers and engines share the same deadline, thus it returns
+within timeout plus CAPTURE_FLUSH_TIMEOUT.
+*/
+func Shutdown(timeout time.Duration, servers []*http.Server, engines []*Engine) {
+	logger := engines[0].Logger
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				logger.Warn("Drain timeout expired. Closing the remaining connections.",
+					zap.String("address", srv.Addr), zap.Error(err))
+				srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+	flushCtx, flushCancel := context.WithDeadline(context.Background(), deadline.Add(CAPTURE_FLUSH_TIMEOUT))
+	defer flushCancel()
+	for _, engine := range engines {
+		if !engine.waitInFlight(flushCtx) {
+			engine.Logger.Warn("Some requests were not finished. Their captures may be lost.")
+		}
+		engine.logStats()
+	}
+}
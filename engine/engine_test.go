@@ -0,0 +1,214 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+// Creates a new engine that captures into a temporary directory.
+func newTestEngine(t *testing.T, cfg *config.Config) *Engine {
+	cfg.CaptureDir = t.TempDir()
+	if cfg.MaxRequestSize == 0 {
+		cfg.MaxRequestSize = 1024
+	}
+	e, err := NewEngine(cfg)
+	require.Nil(t, err)
+	return e
+}
+
+// Loads all captures saved by the engine.
+func loadCaptures(t *testing.T, e *Engine) []*capture.CapturedRequest {
+	caps, err := e.Captures.List(nil)
+	require.Nil(t, err)
+	return caps
+}
+
+func TestEngine_ServeHTTP(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Name: "s1",
+		Responses: []*config.ResponseConfig{
+			{PathPattern: "^/a$", Body: "MTIz", ReturnCode: 201},
+			{ID: "nocap", PathPattern: "^/b$", SkipCapture: true},
+		},
+	})
+
+	resp := httptest.NewRecorder()
+	e.ServeHTTP(resp, httptest.NewRequest("POST", "/a", bytes.NewReader([]byte("body"))))
+	assert.Equal(t, 201, resp.Code)
+	assert.Equal(t, "123", resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	e.ServeHTTP(resp, httptest.NewRequest("GET", "/b", nil))
+	assert.Equal(t, 200, resp.Code)
+
+	caps := loadCaptures(t, e)
+	require.Len(t, caps, 1)
+	assert.Equal(t, "response-0", caps[0].RuleID)
+	assert.Equal(t, "s1", caps[0].Listener)
+	assert.Equal(t, []byte("body"), caps[0].Body)
+}
+
+func TestEngine_selectResponse(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		BodyOverflow: "reject",
+		Responses: []*config.ResponseConfig{
+			{ID: "a", PathPattern: "^/a$"},
+			{ID: "any", BodyOverflow: "spool"},
+		},
+		VirtualHosts: []*config.VirtualHostConfig{
+			{Name: "api", Hosts: []string{"api.local"}, Responses: []*config.ResponseConfig{{ID: "b", PathPattern: "^/b$"}}},
+		},
+	})
+	for _, c := range []struct {
+		url      string
+		id       string
+		vhost    string
+		unknown  bool
+		overflow OverflowPolicy
+		checked  []string
+	}{
+		{"http://api.local/b", "b", "api", false, OVERFLOW_REJECT, []string{"b"}},
+		{"http://api.local/a", DEFAULT_RESPONSE_ID, "api", false, OVERFLOW_REJECT, []string{"b"}},
+		{"http://other/a", "a", "", true, OVERFLOW_REJECT, []string{"a", "any"}},
+		{"http://other/c", "any", "", true, OVERFLOW_SPOOL, []string{"a", "any"}},
+	} {
+		request := httptest.NewRequest("GET", c.url, nil)
+		var checked []string
+		for _, sel := range []selection{
+			e.selectResponse(request, nil),
+			e.selectResponse(request, func(r Response) bool {
+				checked = append(checked, r.ID())
+				return r.MatchRequest(request)
+			}),
+		} {
+			assert.Equal(t, c.id, sel.response.ID(), c.url)
+			if c.vhost == "" {
+				assert.Nil(t, sel.vhost, c.url)
+			} else {
+				assert.Equal(t, c.vhost, sel.vhost.Name, c.url)
+			}
+			assert.Equal(t, c.unknown, sel.unknownHost, c.url)
+			assert.Equal(t, c.overflow, sel.overflow, c.url)
+		}
+		assert.Equal(t, c.checked, checked, c.url)
+	}
+}
+
+func TestEngine_servers(t *testing.T) {
+	e := newTestEngine(t, &config.Config{Address: ":8081", ReadTimeout: 1, WriteTimeout: 2})
+	servers := e.servers()
+	require.Len(t, servers, 1)
+	assert.Equal(t, ":8081", servers[0].Addr)
+	assert.Equal(t, time.Second, servers[0].ReadTimeout)
+	assert.Equal(t, 2*time.Second, servers[0].WriteTimeout)
+	assert.Nil(t, servers[0].TLSConfig)
+
+	e = newTestEngine(t, &config.Config{Address: ":8081",
+		Admin: config.AdminConfig{Enabled: true, Prefix: "/_admin", Address: ":8082"}})
+	servers = e.servers()
+	require.Len(t, servers, 2)
+	assert.Equal(t, ":8082", servers[1].Addr)
+	assert.Equal(t, e.admin, servers[1].Handler)
+}
+
+func TestEngine_ServeHTTPOverflow(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		MaxRequestSize: 4,
+		Responses: []*config.ResponseConfig{
+			{PathPattern: "^/reject$", BodyOverflow: "reject"},
+			{PathPattern: "^/spool$", BodyOverflow: "spool"},
+		},
+	})
+
+	resp := httptest.NewRecorder()
+	e.ServeHTTP(resp, httptest.NewRequest("POST", "/truncate", bytes.NewReader([]byte("1234567890"))))
+	assert.Equal(t, 200, resp.Code)
+
+	resp = httptest.NewRecorder()
+	e.ServeHTTP(resp, httptest.NewRequest("POST", "/reject", bytes.NewReader([]byte("1234567890"))))
+	assert.Equal(t, 413, resp.Code)
+
+	resp = httptest.NewRecorder()
+	e.ServeHTTP(resp, httptest.NewRequest("POST", "/spool", bytes.NewReader([]byte("1234567890"))))
+	assert.Equal(t, 200, resp.Code)
+
+	caps := loadCaptures(t, e)
+	require.Len(t, caps, 3)
+	for _, c := range caps {
+		assert.True(t, c.Truncated)
+		assert.Equal(t, []byte("1234"), c.Body)
+		assert.Equal(t, int64(10), c.BodySize)
+		if strings.HasSuffix(c.URL, "/spool") {
+			data, err := os.ReadFile(path.Join(e.Config.CaptureDir, c.BodyFile))
+			require.Nil(t, err)
+			assert.Equal(t, "1234567890", string(data))
+		} else {
+			assert.Equal(t, "", c.BodyFile)
+		}
+	}
+
+	e.Config.BodyOverflow = "x"
+	_, err := NewEngine(e.Config)
+	assert.ErrorContains(t, err, "invalid body overflow policy 'x'")
+}
+
+func TestEngine_ServeHTTPDecodeBody(t *testing.T) {
+	e := newTestEngine(t, &config.Config{DecodeBody: true, KeepEncodedBody: true})
+
+	encoded := bytes.NewBuffer(nil)
+	writer := gzip.NewWriter(encoded)
+	_, err := writer.Write([]byte("a=1&b=2"))
+	require.Nil(t, err)
+	require.Nil(t, writer.Close())
+
+	request := httptest.NewRequest("POST", "/a", bytes.NewReader(encoded.Bytes()))
+	request.Header.Set("Content-Encoding", "gzip")
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp := httptest.NewRecorder()
+	e.ServeHTTP(resp, request)
+	assert.Equal(t, 200, resp.Code)
+
+	caps := loadCaptures(t, e)
+	require.Len(t, caps, 1)
+	assert.Equal(t, []byte("a=1&b=2"), caps[0].Body)
+	assert.Equal(t, encoded.Bytes(), caps[0].EncodedBody)
+	assert.Equal(t, []string{"gzip"}, caps[0].ContentEncoding)
+	assert.Equal(t, []string{"1"}, caps[0].Form["a"])
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+	"go.uber.org/zap"
+)
+
+// Result of checking a rule against a request.
+type RuleExplanation struct {
+	ID string
+	// True if all conditions of the rule match.
+	Matched bool
+	// True if this rule is the one used to reply.
+	Selected bool
+	// Conditions that failed.
+	Failures []string
+}
+
+// Explains how the engine handles a request.
+type Explanation struct {
+	// Name of the server.
+	Server string
+	// Host name used to select the virtual host.
+	Host string
+	// Set if the request is handled by the admin API or by the health
+	// endpoints and never reaches the rules.
+	Handler string
+	// Name of the selected virtual host, if any.
+	VirtualHost string
+	// True if virtual hosts are defined but none matches the host.
+	UnknownHost bool
+	// The rules checked, in order.
+	Rules []*RuleExplanation
+	// The selected response.
+	Response Response
+	// True if the body is too large and the request is rejected with 413.
+	Rejected bool
+	// The response that would be sent.
+	Result *httptest.ResponseRecorder
+}
+
+// Checks the conditions of the response against the request.
+func explainRule(resp Response, request *http.Request) *RuleExplanation {
+	ret := &RuleExplanation{ID: resp.ID()}
+	r, ok := resp.(*responseImpl)
+	if !ok {
+		ret.Matched = resp.MatchRequest(request)
+		if !ret.Matched {
+			ret.Failures = append(ret.Failures, "the request does not match")
+		}
+		return ret
+	}
+	if !r.MatchMethods(request.Method) {
+		var methods []string
+		for m := range r.methods {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		ret.Failures = append(ret.Failures, fmt.Sprintf("method '%s' is not one of %s",
+			request.Method, strings.Join(methods, ", ")))
+	}
+	if !r.MatchPath(request.URL.Path) {
+		ret.Failures = append(ret.Failures, fmt.Sprintf("path '%s' does not match '%s'",
+			request.URL.Path, r.pathPattern.String()))
+	}
+	if !r.MatchClientCert(request.TLS) {
+		ret.Failures = append(ret.Failures, "the client certificate does not match")
+	}
+	ret.Matched = len(ret.Failures) == 0
+	return ret
+}
+
+/*
+Explains how the request would be handled without handling it. The request
+body is read to check its size.
+*/
+func (e *Engine) Explain(request *http.Request) *Explanation {
+	x := &Explanation{
+		Server: e.Config.Name,
+		Host:   RequestHost(request),
+		Result: httptest.NewRecorder(),
+	}
+	health := e.Config.Health
+	if health.Enabled && (request.URL.Path == health.LivePath || request.URL.Path == health.ReadyPath) {
+		x.Handler = "health"
+		return x
+	}
+	if e.admin != nil && e.Config.Admin.Address == "" && e.admin.Owns(request.URL.Path) {
+		x.Handler = "admin"
+		return x
+	}
+
+	sel := e.selectResponse(request, func(resp Response) bool {
+		rule := explainRule(resp, request)
+		x.Rules = append(x.Rules, rule)
+		return rule.Matched
+	})
+	x.Response = sel.response
+	if sel.vhost != nil {
+		x.VirtualHost = sel.vhost.Name
+	}
+	x.UnknownHost = sel.unknownHost
+	for _, rule := range x.Rules {
+		if rule.Matched {
+			rule.Selected = true
+			break
+		}
+	}
+
+	// Body size
+	if sel.overflow == OVERFLOW_REJECT && request.Body != nil {
+		size, _ := io.Copy(io.Discard, request.Body)
+		x.Rejected = size > int64(e.Config.MaxRequestSize)
+	}
+	if x.Rejected {
+		http.Error(x.Result, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+	} else {
+		WriteResponse(x.Response, x.Result)
+	}
+	return x
+}
+
+// Writes the explanation in a human readable format.
+func (x *Explanation) Write(w io.Writer) {
+	fmt.Fprintf(w, "Server: %s\n", x.Server)
+	if x.Host != "" {
+		fmt.Fprintf(w, "Host: %s\n", x.Host)
+	}
+	if x.Handler != "" {
+		fmt.Fprintf(w, "Handled by the %s endpoints. The request is not captured nor matched against the rules.\n", x.Handler)
+		return
+	}
+	if x.VirtualHost != "" {
+		fmt.Fprintf(w, "Virtual host: %s\n", x.VirtualHost)
+	} else if x.UnknownHost {
+		fmt.Fprintf(w, "Virtual host: none, the unknown host policy is used\n")
+	}
+	fmt.Fprintf(w, "Rules:\n")
+	if len(x.Rules) == 0 {
+		fmt.Fprintf(w, "  none\n")
+	}
+	for i, rule := range x.Rules {
+		switch {
+		case rule.Selected:
+			fmt.Fprintf(w, "  %d. %s: matched (selected)\n", i, rule.ID)
+		case rule.Matched:
+			fmt.Fprintf(w, "  %d. %s: matched (not reached)\n", i, rule.ID)
+		default:
+			fmt.Fprintf(w, "  %d. %s: not matched: %s\n", i, rule.ID, strings.Join(rule.Failures, "; "))
+		}
+	}
+	fmt.Fprintf(w, "Response: %s\n", x.Response.ID())
+	if x.Rejected {
+		fmt.Fprintf(w, "  The body is larger than maxRequestSize and the request is rejected.\n")
+	}
+	fmt.Fprintf(w, "  Status: %d %s\n", x.Result.Code, http.StatusText(x.Result.Code))
+	header := x.Result.Header()
+	var names []string
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(w, "  %s: %s\n", name, value)
+		}
+	}
+	body := x.Result.Body.Bytes()
+	if len(body) == 0 {
+		return
+	}
+	if utf8.Valid(body) {
+		fmt.Fprintf(w, "  Body:\n%s\n", body)
+	} else {
+		fmt.Fprintf(w, "  Body (%d bytes in base64):\n%s\n", len(body), base64.StdEncoding.EncodeToString(body))
+	}
+}
+
+/*
+Explains how the server with the given name, defined by the configuration file,
+would handle the request. An empty name selects the main server. Nothing is
+captured or logged and TLS settings are ignored.
+*/
+func ExplainRequest(configFile string, name string, request *http.Request) (*Explanation, error) {
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = cfg.Name
+	}
+	if cfg, err = cfg.ServerConfig(name); err != nil {
+		return nil, err
+	}
+	cfg.TLS.Enabled = false
+	cfg.AccessLog.Enabled = false
+	e, err := NewEngine(cfg, WithLogger(zap.NewNop()), WithStore(capture.NewMemoryStore()))
+	if err != nil {
+		return nil, err
+	}
+	return e.Explain(request), nil
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+func TestEngine_Explain(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Responses: []*config.ResponseConfig{
+			{ID: "a", PathPattern: "^/a$", Methods: []string{"PUT", "POST"}},
+			{ID: "b", PathPattern: "^/b$"},
+			{ID: "cert", ClientCertSubject: "CN=x"},
+			{ID: "any", ReturnCode: 201, ContentType: "text/plain", Body: "b2s="},
+			{ID: "other"},
+		},
+	})
+	x := e.Explain(httptest.NewRequest("GET", "/a", nil))
+	assert.Equal(t, "", x.Handler)
+	require.Len(t, x.Rules, 5)
+	assert.Equal(t, &RuleExplanation{ID: "a", Failures: []string{"method 'GET' is not one of POST, PUT"}}, x.Rules[0])
+	assert.Equal(t, &RuleExplanation{ID: "b", Failures: []string{"path '/a' does not match '^/b$'"}}, x.Rules[1])
+	assert.Equal(t, &RuleExplanation{ID: "cert", Failures: []string{"the client certificate does not match"}}, x.Rules[2])
+	assert.Equal(t, &RuleExplanation{ID: "any", Matched: true, Selected: true}, x.Rules[3])
+	assert.Equal(t, &RuleExplanation{ID: "other", Matched: true}, x.Rules[4])
+	assert.Equal(t, "any", x.Response.ID())
+	assert.Equal(t, 201, x.Result.Code)
+	assert.Equal(t, "ok", x.Result.Body.String())
+
+	var out bytes.Buffer
+	x.Write(&out)
+	assert.Equal(t, `Server: 
+Host: example.com
+Rules:
+  0. a: not matched: method 'GET' is not one of POST, PUT
+  1. b: not matched: path '/a' does not match '^/b$'
+  2. cert: not matched: the client certificate does not match
+  3. any: matched (selected)
+  4. other: matched (not reached)
+Response: any
+  Status: 201 Created
+  Content-Type: text/plain
+  Body:
+ok
+`, out.String())
+
+	// Nothing was captured
+	assert.Empty(t, loadCaptures(t, e))
+}
+
+func TestEngine_Explain_Default(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Responses: []*config.ResponseConfig{{ID: "a", PathPattern: "^/a$"}},
+	})
+	x := e.Explain(httptest.NewRequest("GET", "/b", nil))
+	assert.Equal(t, DEFAULT_RESPONSE, x.Response)
+	assert.Equal(t, 200, x.Result.Code)
+	assert.Equal(t, "{}", x.Result.Body.String())
+}
+
+func TestEngine_Explain_Handlers(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Admin:  config.AdminConfig{Enabled: true, Prefix: "/_admin"},
+		Health: config.HealthConfig{Enabled: true, LivePath: "/healthz", ReadyPath: "/readyz"},
+	})
+	assert.Equal(t, "admin", e.Explain(httptest.NewRequest("GET", "/_admin/captures", nil)).Handler)
+	x := e.Explain(httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, "health", x.Handler)
+	var out bytes.Buffer
+	x.Write(&out)
+	assert.Contains(t, out.String(), "Handled by the health endpoints.")
+}
+
+func TestEngine_Explain_VirtualHosts(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		UnknownHost: "reject",
+		VirtualHosts: []*config.VirtualHostConfig{
+			{Name: "api", Hosts: []string{"api.local"}, Responses: []*config.ResponseConfig{{ReturnCode: 202}}},
+		},
+	})
+	x := e.Explain(httptest.NewRequest("GET", "http://api.local:8080/a", nil))
+	assert.Equal(t, "api.local", x.Host)
+	assert.Equal(t, "api", x.VirtualHost)
+	assert.Equal(t, "api-response-0", x.Response.ID())
+	assert.Equal(t, 202, x.Result.Code)
+
+	x = e.Explain(httptest.NewRequest("GET", "http://other.local/a", nil))
+	assert.True(t, x.UnknownHost)
+	assert.Empty(t, x.Rules)
+	assert.Equal(t, MISDIRECTED_RESPONSE, x.Response)
+	var out bytes.Buffer
+	x.Write(&out)
+	assert.Contains(t, out.String(), "Virtual host: none, the unknown host policy is used\n")
+	assert.Contains(t, out.String(), "  Status: 421 Misdirected Request\n")
+}
+
+func TestEngine_Explain_Rejected(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		MaxRequestSize: 4,
+		Responses:      []*config.ResponseConfig{{ID: "a", BodyOverflow: "reject"}},
+	})
+	x := e.Explain(httptest.NewRequest("POST", "/", strings.NewReader("1234")))
+	assert.False(t, x.Rejected)
+	x = e.Explain(httptest.NewRequest("POST", "/", strings.NewReader("12345")))
+	assert.True(t, x.Rejected)
+	assert.Equal(t, 413, x.Result.Code)
+	var out bytes.Buffer
+	x.Write(&out)
+	assert.Contains(t, out.String(), "The body is larger than maxRequestSize and the request is rejected.")
+}
+
+func TestExplainRequest(t *testing.T) {
+	root := t.TempDir()
+	file := path.Join(root, "config.yaml")
+	require.Nil(t, os.WriteFile(file, []byte(`captureDir: `+path.Join(root, "missing")+`
+tls:
+  enabled: true
+  auto: true
+responses:
+  - pathPattern: ^/a$
+    returnCode: 201
+servers:
+  - name: s1
+    address: ":8081"
+    responses:
+      - returnCode: 202
+`), 0644))
+	x, err := ExplainRequest(file, "", httptest.NewRequest("GET", "/a", nil))
+	require.Nil(t, err)
+	assert.Equal(t, "main", x.Server)
+	assert.Equal(t, 201, x.Result.Code)
+
+	x, err = ExplainRequest(file, "s1", httptest.NewRequest("GET", "/a", nil))
+	require.Nil(t, err)
+	assert.Equal(t, 202, x.Result.Code)
+
+	_, err = ExplainRequest(file, "s2", httptest.NewRequest("GET", "/a", nil))
+	assert.ErrorContains(t, err, "unknown server 's2'")
+
+	// No side effects
+	assert.NoDirExists(t, path.Join(root, "missing"))
+}
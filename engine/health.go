@@ -0,0 +1,199 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+const (
+	HEALTH_STATUS_OK          = "ok"
+	HEALTH_STATUS_UNAVAILABLE = "unavailable"
+)
+
+// Response of the health endpoints.
+type HealthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+/*
+Returns nil if the engine is ready to handle requests. It is not ready if the
+last configuration reload failed or if the capture directory is not writable.
+*/
+func (e *Engine) Ready() error {
+	if err := e.ReloadError(); err != nil {
+		return fmt.Errorf("the last configuration reload failed: %w", err)
+	}
+	if store, ok := e.Captures.(*capture.DirStore); ok {
+		if err := checkWritable(store.Dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks if a file can be created inside the given directory.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".ready-*")
+	if err != nil {
+		return fmt.Errorf("the directory '%s' is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+/*
+Handles the request if it targets one of the health endpoints. Returns true if
+the request was handled.
+*/
+func (e *Engine) serveHealth(response http.ResponseWriter, request *http.Request) bool {
+	health := e.Config.Health
+	if !health.Enabled {
+		return false
+	}
+	var err error
+	switch request.URL.Path {
+	case health.LivePath:
+	case health.ReadyPath:
+		err = e.Ready()
+	default:
+		return false
+	}
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writeMethodNotAllowed(response, http.MethodGet, http.MethodHead)
+		return true
+	}
+	if err != nil {
+		writeJSON(response, http.StatusServiceUnavailable, &HealthStatus{Status: HEALTH_STATUS_UNAVAILABLE, Error: err.Error()})
+	} else {
+		writeJSON(response, http.StatusOK, &HealthStatus{Status: HEALTH_STATUS_OK})
+	}
+	return true
+}
+
+/*
+Returns the URL used to reach the given address from the local host. The
+wildcard addresses are replaced by the loopback address.
+*/
+func localURL(address string, secure bool, path string) (string, error) {
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	if _, ok := UnixSocketPath(address); ok {
+		return scheme + "://localhost" + path, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + path, nil
+}
+
+/*
+Queries the readiness endpoint, or the liveness endpoint if live is true, of the
+server with the given name defined by the configuration file. An empty name
+selects the main server. It returns nil if the server is healthy.
+
+The certificate of the server is not verified because the check only reaches
+the local host. The check does not present a client certificate, thus it fails
+early if the server requires one.
+*/
+func HealthCheck(configFile string, name string, live bool, timeout time.Duration) error {
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = cfg.Name
+	}
+	if cfg, err = cfg.ServerConfig(name); err != nil {
+		return err
+	}
+	if !cfg.Health.Enabled {
+		return errors.New("the health endpoints are disabled")
+	}
+	if cfg.TLS.Enabled {
+		clientAuth, err := ParseClientAuth(cfg.TLS.ClientAuth)
+		if err != nil {
+			return err
+		}
+		if clientAuth == tls.RequireAndVerifyClientCert {
+			return fmt.Errorf("the server '%s' requires a client certificate, which the health check does not present", name)
+		}
+	}
+	path := cfg.Health.ReadyPath
+	if live {
+		path = cfg.Health.LivePath
+	}
+	url, err := localURL(cfg.Address, cfg.TLS.Enabled, path)
+	if err != nil {
+		return err
+	}
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	if socket, ok := UnixSocketPath(cfg.Address); ok {
+		transport.DialContext = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var status HealthStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return fmt.Errorf("%s: invalid health response: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if status.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, status.Error)
+		}
+		return errors.New(resp.Status)
+	}
+	return nil
+}
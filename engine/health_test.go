@@ -0,0 +1,146 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+func TestEngine_Ready(t *testing.T) {
+	e := newTestEngine(t, &config.Config{})
+	assert.Nil(t, e.Ready())
+
+	e.reloadError = errors.New("boom")
+	assert.ErrorContains(t, e.Ready(), "the last configuration reload failed: boom")
+	e.reloadError = nil
+
+	e.Captures = &capture.DirStore{Dir: path.Join(e.Config.CaptureDir, "missing")}
+	assert.ErrorContains(t, e.Ready(), "is not writable")
+
+	e.Captures = capture.NewMemoryStore()
+	assert.Nil(t, e.Ready())
+}
+
+func TestEngine_serveHealth(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Health: config.HealthConfig{Enabled: true, LivePath: "/healthz", ReadyPath: "/readyz"},
+	})
+
+	resp := serve(e, "GET", "/healthz", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, resp.Body.String())
+	assert.Equal(t, http.StatusOK, serve(e, "HEAD", "/readyz", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(e, "POST", "/readyz", "").Code)
+
+	e.reloadError = errors.New("boom")
+	resp = serve(e, "GET", "/readyz", "")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	var status HealthStatus
+	require.Nil(t, json.Unmarshal(resp.Body.Bytes(), &status))
+	assert.Equal(t, HEALTH_STATUS_UNAVAILABLE, status.Status)
+	assert.Contains(t, status.Error, "boom")
+	assert.Equal(t, http.StatusOK, serve(e, "GET", "/healthz", "").Code)
+
+	// Never captured nor counted
+	assert.Empty(t, loadCaptures(t, e))
+	assert.Equal(t, int64(0), e.Stats().Requests)
+
+	// Disabled
+	e.Config.Health.Enabled = false
+	serve(e, "GET", "/healthz", "")
+	assert.Len(t, loadCaptures(t, e), 1)
+}
+
+func TestLocalURL(t *testing.T) {
+	for _, c := range []struct {
+		address string
+		secure  bool
+		url     string
+	}{
+		{":8080", false, "http://localhost:8080/readyz"},
+		{"0.0.0.0:8080", true, "https://localhost:8080/readyz"},
+		{"[::]:8080", false, "http://localhost:8080/readyz"},
+		{"127.0.0.2:8080", false, "http://127.0.0.2:8080/readyz"},
+		{"unix:/tmp/s.sock", false, "http://localhost/readyz"},
+	} {
+		url, err := localURL(c.address, c.secure, "/readyz")
+		assert.Nil(t, err)
+		assert.Equal(t, c.url, url)
+	}
+	_, err := localURL("8080", false, "/readyz")
+	assert.NotNil(t, err)
+}
+
+func TestHealthCheck(t *testing.T) {
+	root := t.TempDir()
+	file := path.Join(root, "config.yaml")
+	write := func(contents string) {
+		require.Nil(t, os.WriteFile(file, []byte("captureDir: "+root+"\naddress: unix:"+path.Join(root, "s.sock")+"\n"+contents), 0644))
+	}
+	write("")
+	assert.ErrorContains(t, HealthCheck(file, "", false, time.Second), "the health endpoints are disabled")
+	assert.ErrorContains(t, HealthCheck(file, "other", false, time.Second), "unknown server 'other'")
+
+	write("health:\n  enabled: true\ntls:\n  enabled: true\n  clientAuth: require\n")
+	assert.ErrorContains(t, HealthCheck(file, "", false, time.Second), "requires a client certificate")
+	write("health:\n  enabled: true\ntls:\n  enabled: true\n  clientAuth: bogus\n")
+	assert.ErrorContains(t, HealthCheck(file, "", false, time.Second), "invalid client authentication mode 'bogus'")
+
+	write("health:\n  enabled: true\nresponses:\n  - id: other\n    pathPattern: ^/other$\n    contentType: text/plain\n    body: bm90IGpzb24=\n")
+	assert.NotNil(t, HealthCheck(file, "", false, time.Second))
+
+	cfg, err := config.LoadConfig(file)
+	require.Nil(t, err)
+	e, err := NewEngine(cfg)
+	require.Nil(t, err)
+	l, err := e.listen(cfg.Address)
+	require.Nil(t, err)
+	server := &http.Server{Handler: e}
+	go server.Serve(l)
+	defer server.Close()
+
+	assert.Nil(t, HealthCheck(file, "", false, time.Second))
+	e.reloadError = errors.New("boom")
+	assert.ErrorContains(t, HealthCheck(file, "", false, time.Second), "503 Service Unavailable: the last configuration reload failed: boom")
+	assert.Nil(t, HealthCheck(file, "", true, time.Second))
+
+	// A response that is not a health status is rejected.
+	write("health:\n  enabled: true\n  readyPath: /other\n")
+	e.reloadError = nil
+	assert.ErrorContains(t, HealthCheck(file, "", false, time.Second), "invalid health response")
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+)
+
+const (
+	// Prefix of the addresses of Unix domain sockets.
+	UNIX_ADDRESS_PREFIX = "unix:"
+	// Default permissions of the Unix domain sockets.
+	DEFAULT_SOCKET_MODE = "0660"
+)
+
+// Key of the peer credentials in the connection context.
+type peerCredentialsKey struct{}
+
+// Returns the path of the socket if the address is a Unix domain socket address.
+func UnixSocketPath(address string) (string, bool) {
+	if strings.HasPrefix(address, UNIX_ADDRESS_PREFIX) {
+		return strings.TrimPrefix(address, UNIX_ADDRESS_PREFIX), true
+	}
+	return "", false
+}
+
+// Parses the permissions of a socket file in octal, like "0660". The empty
+// string is parsed as DEFAULT_SOCKET_MODE.
+func ParseSocketMode(s string) (fs.FileMode, error) {
+	if s == "" {
+		s = DEFAULT_SOCKET_MODE
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid socket mode '%s'", s)
+	}
+	return fs.FileMode(mode), nil
+}
+
+/*
+Removes the socket file if no server is listening on it. It fails if the file
+exists but it is not a socket or if another server is still using it.
+*/
+func removeStaleSocket(socket string) error {
+	stat, err := os.Lstat(socket)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if stat.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("'%s' exists and it is not a socket", socket)
+	}
+	if conn, err := net.DialTimeout("unix", socket, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("the socket '%s' is in use", socket)
+	}
+	return os.Remove(socket)
+}
+
+/*
+Creates the listener of the given address. Unix domain sockets are created with
+the configured permissions after removing a stale socket file. The socket file
+is removed when the listener is closed.
+*/
+func (e *Engine) listen(address string) (net.Listener, error) {
+	socket, ok := UnixSocketPath(address)
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+	mode, err := ParseSocketMode(e.Config.SocketMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(socket); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+/*
+Adds the credentials of the peer to the connection context if the connection
+uses a Unix domain socket and the platform supports it.
+*/
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	creds, err := peerCredentials(unixConn)
+	if err != nil || creds == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredentialsKey{}, creds)
+}
+
+// Returns the credentials of the peer stored in the context or nil.
+func peerCredentialsFromContext(ctx context.Context) *capture.PeerCredentials {
+	creds, _ := ctx.Value(peerCredentialsKey{}).(*capture.PeerCredentials)
+	return creds
+}
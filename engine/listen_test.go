@@ -0,0 +1,132 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"context"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+func TestUnixSocketPath(t *testing.T) {
+	p, ok := UnixSocketPath("unix:/tmp/a.sock")
+	assert.True(t, ok)
+	assert.Equal(t, "/tmp/a.sock", p)
+	_, ok = UnixSocketPath(":8080")
+	assert.False(t, ok)
+}
+
+func TestParseSocketMode(t *testing.T) {
+	for s, exp := range map[string]fs.FileMode{
+		"":     0660,
+		"0600": 0600,
+		"777":  0777,
+	} {
+		mode, err := ParseSocketMode(s)
+		assert.Nil(t, err)
+		assert.Equal(t, exp, mode)
+	}
+	for _, s := range []string{"x", "0800", "1777"} {
+		_, err := ParseSocketMode(s)
+		assert.ErrorContains(t, err, "invalid socket mode")
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := path.Join(dir, "s.sock")
+	assert.Nil(t, removeStaleSocket(socket))
+
+	// Regular file
+	file := path.Join(dir, "file")
+	require.Nil(t, os.WriteFile(file, nil, 0644))
+	assert.ErrorContains(t, removeStaleSocket(file), "exists and it is not a socket")
+
+	// Socket in use
+	l, err := net.Listen("unix", socket)
+	require.Nil(t, err)
+	assert.ErrorContains(t, removeStaleSocket(socket), "is in use")
+
+	// Stale socket
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	_, err = os.Lstat(socket)
+	require.Nil(t, err)
+	assert.Nil(t, removeStaleSocket(socket))
+	_, err = os.Lstat(socket)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestEngine_ServeUnixSocket(t *testing.T) {
+	socket := path.Join(t.TempDir(), "s.sock")
+	e := newTestEngine(t, &config.Config{Address: UNIX_ADDRESS_PREFIX + socket, SocketMode: "0600"})
+	srv := e.servers()[0]
+	l, err := e.listen(srv.Addr)
+	require.Nil(t, err)
+	stat, err := os.Stat(socket)
+	require.Nil(t, err)
+	assert.Equal(t, fs.FileMode(0600), stat.Mode().Perm())
+	go srv.Serve(l)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://localhost/a")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	client.CloseIdleConnections()
+	require.Nil(t, srv.Shutdown(context.Background()))
+	_, err = os.Lstat(socket)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	caps := loadCaptures(t, e)
+	require.Len(t, caps, 1)
+	assert.Equal(t, socket, caps[0].Local)
+	if runtime.GOOS == "linux" {
+		require.NotNil(t, caps[0].Peer)
+		assert.Equal(t, os.Getuid(), caps[0].Peer.UID)
+		assert.Equal(t, os.Getgid(), caps[0].Peer.GID)
+		assert.Equal(t, os.Getpid(), caps[0].Peer.PID)
+	}
+
+	// Invalid mode
+	e.Config.SocketMode = "x"
+	_, err = e.listen(srv.Addr)
+	assert.NotNil(t, err)
+}
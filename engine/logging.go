@@ -0,0 +1,128 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// Default name of the log file inside the capture directory.
+	DEFAULT_LOG_FILE   = "log.log"
+	LOG_OUTPUT_FILE    = "file"
+	LOG_OUTPUT_STDOUT  = "stdout"
+	LOG_OUTPUT_STDERR  = "stderr"
+	LOG_FORMAT_JSON    = "json"
+	LOG_FORMAT_CONSOLE = "console"
+)
+
+// Returns the log file of the given configuration.
+func LogFile(cfg *config.Config) string {
+	if cfg.Logging.File != "" {
+		return cfg.Logging.File
+	}
+	return path.Join(cfg.CaptureDir, DEFAULT_LOG_FILE)
+}
+
+// Parses the log level. The empty string is parsed as info.
+func ParseLogLevel(s string) (zapcore.Level, error) {
+	if s == "" {
+		return zapcore.InfoLevel, nil
+	}
+	level, err := zapcore.ParseLevel(s)
+	if err != nil {
+		return level, fmt.Errorf("invalid log level '%s'", s)
+	}
+	return level, nil
+}
+
+// Creates the encoder of the given format. The empty string selects json.
+func newLogEncoder(format string) (zapcore.Encoder, error) {
+	switch strings.ToLower(format) {
+	case "", LOG_FORMAT_JSON:
+		return zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), nil
+	case LOG_FORMAT_CONSOLE:
+		encoderConfig := zap.NewDevelopmentEncoderConfig()
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		return zapcore.NewConsoleEncoder(encoderConfig), nil
+	default:
+		return nil, fmt.Errorf("invalid log format '%s'", format)
+	}
+}
+
+/*
+Creates the logger defined by the logging settings of the given configuration.
+If no output is set, the log is written into the log file.
+*/
+func NewLogger(cfg *config.Config) (*zap.Logger, error) {
+	logging := &cfg.Logging
+	level, err := ParseLogLevel(logging.Level)
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := newLogEncoder(logging.Format)
+	if err != nil {
+		return nil, err
+	}
+	outputs := logging.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{LOG_OUTPUT_FILE}
+	}
+	var syncers []zapcore.WriteSyncer
+	for _, output := range outputs {
+		switch strings.ToLower(output) {
+		case LOG_OUTPUT_FILE:
+			file, err := OpenRotatingFile(LogFile(cfg), int64(logging.Rotation.MaxSize)*1024*1024,
+				logging.Rotation.MaxBackups)
+			if err != nil {
+				return nil, fmt.Errorf("unable to open the log file: %w", err)
+			}
+			syncers = append(syncers, file)
+		case LOG_OUTPUT_STDOUT:
+			syncers = append(syncers, zapcore.Lock(os.Stdout))
+		case LOG_OUTPUT_STDERR:
+			syncers = append(syncers, zapcore.Lock(os.Stderr))
+		default:
+			return nil, fmt.Errorf("invalid log output '%s'", output)
+		}
+	}
+	out := zapcore.NewMultiWriteSyncer(syncers...)
+	core := zapcore.NewCore(encoder, out, level)
+	if logging.Sampling.Initial > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, logging.Sampling.Initial,
+			logging.Sampling.Thereafter)
+	}
+	return zap.New(core, zap.ErrorOutput(out), zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)), nil
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("")
+	assert.Nil(t, err)
+	assert.Equal(t, zapcore.InfoLevel, level)
+	level, err = ParseLogLevel("debug")
+	assert.Nil(t, err)
+	assert.Equal(t, zapcore.DebugLevel, level)
+	_, err = ParseLogLevel("loud")
+	assert.ErrorContains(t, err, "invalid log level 'loud'")
+}
+
+func TestLogFile(t *testing.T) {
+	cfg := &config.Config{CaptureDir: "var"}
+	assert.Equal(t, "var/log.log", LogFile(cfg))
+	cfg.Logging.File = "other.log"
+	assert.Equal(t, "other.log", LogFile(cfg))
+}
+
+func TestNewLogger(t *testing.T) {
+	cfg := &config.Config{CaptureDir: t.TempDir()}
+
+	// Defaults
+	logger, err := NewLogger(cfg)
+	require.Nil(t, err)
+	logger.Debug("hidden")
+	logger.Info("shown")
+	require.Nil(t, logger.Sync())
+	data, err := os.ReadFile(path.Join(cfg.CaptureDir, DEFAULT_LOG_FILE))
+	require.Nil(t, err)
+	assert.NotContains(t, string(data), "hidden")
+	assert.Contains(t, string(data), `"msg":"shown"`)
+
+	// Console
+	cfg.Logging = config.LoggingConfig{
+		Level:   "debug",
+		Format:  "console",
+		Outputs: []string{"file"},
+		File:    path.Join(cfg.CaptureDir, "console.log"),
+	}
+	logger, err = NewLogger(cfg)
+	require.Nil(t, err)
+	logger.Debug("shown")
+	require.Nil(t, logger.Sync())
+	data, err = os.ReadFile(cfg.Logging.File)
+	require.Nil(t, err)
+	assert.True(t, strings.Contains(string(data), "DEBUG"))
+	assert.False(t, strings.HasPrefix(string(data), "{"))
+
+	// Sampling
+	cfg.Logging.File = path.Join(cfg.CaptureDir, "sampled.log")
+	cfg.Logging.Sampling = config.LogSamplingConfig{Initial: 2, Thereafter: 100}
+	logger, err = NewLogger(cfg)
+	require.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		logger.Info("repeated")
+	}
+	require.Nil(t, logger.Sync())
+	data, err = os.ReadFile(cfg.Logging.File)
+	require.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "repeated"))
+}
+
+func TestNewLogger_Errors(t *testing.T) {
+	cfg := &config.Config{CaptureDir: t.TempDir()}
+	cfg.Logging.Format = "xml"
+	_, err := NewLogger(cfg)
+	assert.ErrorContains(t, err, "invalid log format 'xml'")
+
+	cfg.Logging.Format = ""
+	cfg.Logging.Outputs = []string{"syslog"}
+	_, err = NewLogger(cfg)
+	assert.ErrorContains(t, err, "invalid log output 'syslog'")
+
+	cfg.Logging.Outputs = nil
+	cfg.Logging.Level = "loud"
+	_, err = NewLogger(cfg)
+	assert.ErrorContains(t, err, "invalid log level")
+
+	// The engine reports the error instead of running without a logger
+	cfg.Logging.Level = ""
+	cfg.CaptureDir = path.Join(cfg.CaptureDir, "missing")
+	_, err = NewEngine(cfg)
+	assert.ErrorContains(t, err, "unable to open the log file")
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Buckets of the request duration histogram in seconds.
+	DURATION_BUCKETS = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	// Buckets of the body size histograms in bytes.
+	SIZE_BUCKETS = []float64{0, 64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+	// Methods used as labels. Other methods are reported as "OTHER".
+	METRIC_METHODS = map[string]bool{
+		http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+		http.MethodPatch: true, http.MethodDelete: true, http.MethodConnect: true,
+		http.MethodOptions: true, http.MethodTrace: true,
+	}
+)
+
+// A histogram with fixed buckets. It is not safe for concurrent use.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Writes the histogram samples in the Prometheus text format.
+func (h *histogram) write(w io.Writer, name string, labels string) {
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatFloat(b), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, strings.TrimSuffix(labels, ","), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, strings.TrimSuffix(labels, ","), h.count)
+}
+
+// Labels of the request counter.
+type requestKey struct {
+	method string
+	status int
+	rule   string
+}
+
+// Metrics of the requests handled by an engine. The counters of Stats are
+// exported too.
+type Metrics struct {
+	mutex        sync.Mutex
+	requests     map[requestKey]uint64
+	duration     *histogram
+	requestSize  *histogram
+	responseSize *histogram
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		requests:     make(map[requestKey]uint64),
+		duration:     newHistogram(DURATION_BUCKETS),
+		requestSize:  newHistogram(SIZE_BUCKETS),
+		responseSize: newHistogram(SIZE_BUCKETS),
+	}
+}
+
+// Records a request.
+func (m *Metrics) observe(method string, status int, rule string, duration time.Duration,
+	requestSize int64, responseSize int64) {
+	if !METRIC_METHODS[method] {
+		method = "OTHER"
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.requests[requestKey{method: method, status: status, rule: rule}]++
+	m.duration.observe(duration.Seconds())
+	m.requestSize.observe(float64(requestSize))
+	m.responseSize.observe(float64(responseSize))
+}
+
+// Formats a float as expected by Prometheus.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Escapes a label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+/*
+Writes the metrics of the engine in the Prometheus text exposition format. All
+samples have the label "server" with the name of the server.
+*/
+func (e *Engine) WriteMetrics(w io.Writer) {
+	m := e.metrics
+	stats := e.Stats()
+	server := fmt.Sprintf("server=\"%s\",", escapeLabel(e.Config.Name))
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		if a.status != b.status {
+			return a.status < b.status
+		}
+		return a.rule < b.rule
+	})
+	fmt.Fprintln(w, "# HELP dummy_http_requests_total Requests received by method, status and matched rule.")
+	fmt.Fprintln(w, "# TYPE dummy_http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "dummy_http_requests_total{%smethod=\"%s\",status=\"%d\",rule=\"%s\"} %d\n",
+			server, k.method, k.status, escapeLabel(k.rule), m.requests[k])
+	}
+	fmt.Fprintln(w, "# HELP dummy_http_request_duration_seconds Time spent handling the requests.")
+	fmt.Fprintln(w, "# TYPE dummy_http_request_duration_seconds histogram")
+	m.duration.write(w, "dummy_http_request_duration_seconds", server)
+	fmt.Fprintln(w, "# HELP dummy_http_request_size_bytes Size of the request bodies.")
+	fmt.Fprintln(w, "# TYPE dummy_http_request_size_bytes histogram")
+	m.requestSize.write(w, "dummy_http_request_size_bytes", server)
+	fmt.Fprintln(w, "# HELP dummy_http_response_size_bytes Size of the response bodies.")
+	fmt.Fprintln(w, "# TYPE dummy_http_response_size_bytes histogram")
+	m.responseSize.write(w, "dummy_http_response_size_bytes", server)
+	fmt.Fprintln(w, "# HELP dummy_http_captures_total Captures saved or failed.")
+	fmt.Fprintln(w, "# TYPE dummy_http_captures_total counter")
+	fmt.Fprintf(w, "dummy_http_captures_total{%sresult=\"success\"} %d\n", server, stats.Captures)
+	fmt.Fprintf(w, "dummy_http_captures_total{%sresult=\"failure\"} %d\n", server, stats.CaptureErrors)
+	fmt.Fprintln(w, "# HELP dummy_http_response_errors_total Responses that could not be sent.")
+	fmt.Fprintln(w, "# TYPE dummy_http_response_errors_total counter")
+	fmt.Fprintf(w, "dummy_http_response_errors_total{%s} %d\n", strings.TrimSuffix(server, ","), stats.ResponseErrors)
+	fmt.Fprintln(w, "# HELP dummy_http_requests_in_flight Requests being handled.")
+	fmt.Fprintln(w, "# TYPE dummy_http_requests_in_flight gauge")
+	fmt.Fprintf(w, "dummy_http_requests_in_flight{%s} %d\n", strings.TrimSuffix(server, ","), stats.InFlight)
+}
+
+// Content type of the Prometheus text exposition format.
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// Serves the metrics of the engine.
+func (e *Engine) serveMetrics(response http.ResponseWriter) {
+	response.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	e.WriteMetrics(response)
+}
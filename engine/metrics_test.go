@@ -0,0 +1,124 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 10})
+	h.observe(0.5)
+	h.observe(5)
+	h.observe(50)
+	assert.Equal(t, []uint64{1, 2}, h.counts)
+	assert.Equal(t, uint64(3), h.count)
+	assert.Equal(t, 55.5, h.sum)
+
+	out := bytes.NewBuffer(nil)
+	h.write(out, "m", `a="b",`)
+	assert.Equal(t, `m_bucket{a="b",le="1"} 1
+m_bucket{a="b",le="10"} 2
+m_bucket{a="b",le="+Inf"} 3
+m_sum{a="b"} 55.5
+m_count{a="b"} 3
+`, out.String())
+}
+
+func TestEscapeLabel(t *testing.T) {
+	assert.Equal(t, `a\\b\"c\nd`, escapeLabel("a\\b\"c\nd"))
+}
+
+func TestMetrics_observe(t *testing.T) {
+	m := newMetrics()
+	m.observe("GET", 200, "r1", time.Millisecond, 10, 20)
+	m.observe("GET", 200, "r1", time.Millisecond, 10, 20)
+	m.observe("X", 404, "r2", time.Millisecond, 10, 20)
+	assert.Equal(t, map[requestKey]uint64{
+		{method: "GET", status: 200, rule: "r1"}:   2,
+		{method: "OTHER", status: 404, rule: "r2"}: 1,
+	}, m.requests)
+	assert.Equal(t, uint64(3), m.duration.count)
+	assert.Equal(t, float64(30), m.requestSize.sum)
+	assert.Equal(t, float64(60), m.responseSize.sum)
+}
+
+func TestEngine_Metrics(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Name:  "main",
+		Admin: config.AdminConfig{Enabled: true, Prefix: "/_admin"},
+		Responses: []*config.ResponseConfig{
+			{ID: "a", PathPattern: "^/a$", Body: "MTIz", ReturnCode: 201},
+		},
+	})
+	serve(e, "POST", "/a", "12345")
+	serve(e, "GET", "/b", "")
+	serve(e, "GET", "/b", "")
+
+	resp := serve(e, "GET", "/_admin/metrics", "")
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, METRICS_CONTENT_TYPE, resp.Header().Get("Content-Type"))
+	body := resp.Body.String()
+	for _, line := range []string{
+		"# TYPE dummy_http_requests_total counter",
+		`dummy_http_requests_total{server="main",method="GET",status="200",rule="default"} 2`,
+		`dummy_http_requests_total{server="main",method="POST",status="201",rule="a"} 1`,
+		"# TYPE dummy_http_request_duration_seconds histogram",
+		`dummy_http_request_duration_seconds_count{server="main"} 3`,
+		`dummy_http_request_size_bytes_bucket{server="main",le="0"} 2`,
+		`dummy_http_request_size_bytes_sum{server="main"} 5`,
+		`dummy_http_response_size_bytes_sum{server="main"} 7`,
+		`dummy_http_captures_total{server="main",result="success"} 3`,
+		`dummy_http_captures_total{server="main",result="failure"} 0`,
+		`dummy_http_response_errors_total{server="main"} 0`,
+		`dummy_http_requests_in_flight{server="main"} 0`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.Equal(t, 405, serve(e, "POST", "/_admin/metrics", "").Code)
+
+	// The metrics are not counted
+	assert.Equal(t, int64(3), e.Stats().Requests)
+
+	// Separate admin listener
+	e = newTestEngine(t, &config.Config{
+		Admin: config.AdminConfig{Enabled: true, Prefix: "/_admin", Address: ":0"},
+	})
+	resp = httptest.NewRecorder()
+	e.admin.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, resp.Body.String(), "# TYPE dummy_http_requests_total counter\n")
+	resp = httptest.NewRecorder()
+	e.admin.ServeHTTP(resp, httptest.NewRequest("GET", "/_admin/metrics", nil))
+	assert.Equal(t, 200, resp.Code)
+}
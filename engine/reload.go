@@ -0,0 +1,185 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/fsnotify/fsnotify"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+	"go.uber.org/zap"
+)
+
+/*
+Returns the names of the settings that differ between the two configurations
+and cannot be applied without a restart. Only the responses and the virtual
+hosts can be changed live. Servers added or removed are checked by reloadAll().
+*/
+func RestartRequired(current *config.Config, next *config.Config) []string {
+	var ret []string
+	c := reflect.ValueOf(current).Elem()
+	n := reflect.ValueOf(next).Elem()
+	t := c.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Name == "Responses" || field.Name == "VirtualHosts" ||
+			field.Name == "Servers" {
+			continue
+		}
+		if !reflect.DeepEqual(c.Field(i).Interface(), n.Field(i).Interface()) {
+			ret = append(ret, field.Name)
+		}
+	}
+	return ret
+}
+
+/*
+Applies the responses and the virtual hosts of the given configuration. All
+responses are validated before being swapped atomically into the engine. If any
+of them is invalid, the current responses are kept and an error is returned.
+
+Other settings that differ from the current configuration are logged as
+requiring a restart.
+*/
+func (e *Engine) ApplyConfig(next *config.Config) error {
+	responses, errs := NewResponsesFromConfig(next.Responses)
+	vhosts, vhostErr := NewVirtualHostsFromConfig(next.VirtualHosts)
+	if len(errs) > 0 || vhostErr != nil {
+		joined := make([]error, 0, len(errs)+1)
+		for _, err := range errs {
+			joined = append(joined, err)
+		}
+		return errors.Join(append(joined, vhostErr)...)
+	}
+	for _, name := range RestartRequired(e.Config, next) {
+		e.Logger.Warn("Setting changed but it requires a restart.", zap.String("setting", name))
+	}
+	e.configMutex.Lock()
+	defer e.configMutex.Unlock()
+	e.Config.Responses = next.Responses
+	e.Config.VirtualHosts = next.VirtualHosts
+	e.Responses.SetResponses(responses)
+	e.vhosts.Store(&vhosts)
+	return nil
+}
+
+/*
+Reloads the configuration file and applies its responses. See ApplyConfig() for
+further details. The result is logged and reported by ReloadError().
+*/
+func (e *Engine) Reload() error {
+	var err error
+	if source := e.Config.GetSource(); source == nil || source.ConfigFileUsed() == "" {
+		err = fmt.Errorf("the configuration file is unknown")
+	} else {
+		var next *config.Config
+		next, err = config.LoadConfig(source.ConfigFileUsed())
+		if err == nil {
+			next, err = next.ServerConfig(e.Config.Name)
+		}
+		if err == nil {
+			err = e.ApplyConfig(next)
+		}
+	}
+	e.configMutex.Lock()
+	e.reloadError = err
+	e.configMutex.Unlock()
+	if err != nil {
+		e.Logger.Error("Unable to reload the configuration. Keeping the current responses.", zap.Error(err))
+	} else {
+		e.Logger.Info("Configuration reloaded.")
+	}
+	return err
+}
+
+/*
+Returns the error of the last reload or nil if it succeeded or no reload was
+attempted.
+*/
+func (e *Engine) ReloadError() error {
+	e.configMutex.Lock()
+	defer e.configMutex.Unlock()
+	return e.reloadError
+}
+
+/*
+Reloads the configuration of all engines. Servers added to the configuration or
+removed from it are logged as requiring a restart. Removed servers keep their
+current responses and are not reloaded.
+*/
+func reloadAll(engines []*Engine) {
+	var names map[string]bool
+	if source := engines[0].Config.GetSource(); source != nil && source.ConfigFileUsed() != "" {
+		// Errors are reported by Reload()
+		if next, err := config.LoadConfig(source.ConfigFileUsed()); err == nil {
+			if configs, err := next.ServerConfigs(); err == nil {
+				names = make(map[string]bool, len(configs))
+				for _, c := range configs {
+					names[c.Name] = true
+				}
+			}
+		}
+	}
+	if names != nil {
+		current := make(map[string]bool, len(engines))
+		for _, e := range engines {
+			current[e.Config.Name] = true
+		}
+		for name := range names {
+			if !current[name] {
+				engines[0].Logger.Warn("Server added but it requires a restart.", zap.String("server", name))
+			}
+		}
+	}
+	for _, e := range engines {
+		if names != nil && !names[e.Config.Name] {
+			e.Logger.Warn("Server removed but it requires a restart. Keeping the current responses.",
+				zap.String("server", e.Config.Name))
+			continue
+		}
+		e.Reload()
+	}
+}
+
+/*
+Reloads the configuration of all engines whenever the configuration file
+changes. The engines must share the same configuration source.
+*/
+func watchConfig(engines []*Engine) {
+	e := engines[0]
+	source := e.Config.GetSource()
+	if source == nil {
+		return
+	}
+	source.OnConfigChange(func(in fsnotify.Event) {
+		e.Logger.Info("Configuration file changed.", zap.String("file", in.Name))
+		reloadAll(engines)
+	})
+	source.WatchConfig()
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRestartRequired(t *testing.T) {
+	c1 := &config.Config{Address: ":8080", Responses: []*config.ResponseConfig{{}}}
+	c2 := &config.Config{Address: ":8080"}
+	assert.Nil(t, RestartRequired(c1, c2))
+
+	c2.Address = ":8081"
+	c2.ReadTimeout = 1
+	c2.Admin.Enabled = true
+	c2.Servers = []*config.ServerConfig{{Name: "s1"}}
+	c2.VirtualHosts = []*config.VirtualHostConfig{{Hosts: []string{"a"}}}
+	assert.Equal(t, []string{"Address", "ReadTimeout", "Admin"}, RestartRequired(c1, c2))
+}
+
+func TestEngine_Reload(t *testing.T) {
+	root := t.TempDir()
+	file := path.Join(root, "config.yaml")
+	write := func(contents string) {
+		require.Nil(t, os.WriteFile(file, []byte("captureDir: "+root+"\n"+contents), 0644))
+	}
+	write("responses:\n  - pathPattern: ^/a$\n    returnCode: 201\n")
+	cfg, err := config.LoadConfig(file)
+	require.Nil(t, err)
+	e, err := NewEngine(cfg)
+	require.Nil(t, err)
+	assert.Equal(t, 201, serve(e, "GET", "/a", "").Code)
+
+	// Valid change
+	write("responses:\n  - pathPattern: ^/a$\n    returnCode: 202\n")
+	assert.Nil(t, e.Reload())
+	assert.Nil(t, e.ReloadError())
+	assert.Equal(t, 202, serve(e, "GET", "/a", "").Code)
+
+	// Invalid rules keep the current ones
+	write("responses:\n  - pathPattern: ^/a$\n    returnCode: 203\n  - pathPattern: \"[\"\n")
+	assert.ErrorContains(t, e.Reload(), "response 1:")
+	assert.NotNil(t, e.ReloadError())
+	assert.Equal(t, 202, serve(e, "GET", "/a", "").Code)
+
+	// Virtual hosts
+	write("virtualHosts:\n  - hosts: [api.local]\n    responses:\n      - returnCode: 203\n")
+	assert.Nil(t, e.Reload())
+	require.Len(t, e.VirtualHosts(), 1)
+	assert.Equal(t, "vhost-0", e.VirtualHosts()[0].Name)
+	write("virtualHosts:\n  - hosts: []\n")
+	assert.ErrorContains(t, e.Reload(), "virtual host 0: at least one host is required")
+	require.Len(t, e.VirtualHosts(), 1)
+	write("responses:\n  - pathPattern: ^/a$\n    returnCode: 202\n")
+	assert.Nil(t, e.Reload())
+	assert.Empty(t, e.VirtualHosts())
+
+	// Invalid file
+	write("responses: [")
+	assert.NotNil(t, e.Reload())
+	assert.Equal(t, 202, serve(e, "GET", "/a", "").Code)
+
+	// Reset uses the reloaded responses
+	write("address: \":9999\"\nresponses:\n  - pathPattern: ^/a$\n    returnCode: 204\n")
+	assert.Nil(t, e.Reload())
+	assert.Nil(t, e.ReloadError())
+	e.Responses.SetResponses(nil)
+	e.ResetResponses()
+	assert.Equal(t, 204, serve(e, "GET", "/a", "").Code)
+	assert.Equal(t, ":8080", e.Config.Address)
+
+	// Without a file
+	e = newTestEngine(t, &config.Config{})
+	assert.ErrorContains(t, e.Reload(), "the configuration file is unknown")
+}
+
+func TestEngine_ReloadServer(t *testing.T) {
+	root := t.TempDir()
+	file := path.Join(root, "config.yaml")
+	write := func(code int) {
+		contents := fmt.Sprintf("captureDir: %s\nservers:\n  - name: s1\n    address: \":9999\"\n"+
+			"    responses:\n      - returnCode: %d\n", root, code)
+		require.Nil(t, os.WriteFile(file, []byte(contents), 0644))
+	}
+	write(201)
+	cfg, err := config.LoadConfig(file)
+	require.Nil(t, err)
+	cfg, err = cfg.ServerConfig("s1")
+	require.Nil(t, err)
+	e, err := NewEngine(cfg)
+	require.Nil(t, err)
+	assert.Equal(t, 201, serve(e, "GET", "/a", "").Code)
+
+	write(202)
+	assert.Nil(t, e.Reload())
+	assert.Equal(t, 202, serve(e, "GET", "/a", "").Code)
+
+	require.Nil(t, os.WriteFile(file, []byte("captureDir: "+root+"\n"), 0644))
+	assert.ErrorContains(t, e.Reload(), "unknown server 's1'")
+	assert.Equal(t, 202, serve(e, "GET", "/a", "").Code)
+}
+
+func TestReloadAll(t *testing.T) {
+	root := t.TempDir()
+	file := path.Join(root, "config.yaml")
+	write := func(servers string, code int) {
+		contents := fmt.Sprintf("captureDir: %s\nresponses:\n  - returnCode: %d\nservers:\n%s", root, code, servers)
+		require.Nil(t, os.WriteFile(file, []byte(contents), 0644))
+	}
+	write("  - name: s1\n    address: \":9998\"\n    responses:\n      - returnCode: 203\n", 201)
+	cfg, err := config.LoadConfig(file)
+	require.Nil(t, err)
+	configs, err := cfg.ServerConfigs()
+	require.Nil(t, err)
+	core, logs := observer.New(zap.InfoLevel)
+	var engines []*Engine
+	for _, c := range configs {
+		e, err := NewEngine(c, WithLogger(zap.New(core)))
+		require.Nil(t, err)
+		engines = append(engines, e)
+	}
+
+	// s1 is removed and s2 is added
+	write("  - name: s2\n    address: \":9999\"\n", 202)
+	reloadAll(engines)
+	assert.Nil(t, engines[0].ReloadError())
+	assert.Equal(t, 202, serve(engines[0], "GET", "/a", "").Code)
+	assert.Nil(t, engines[1].ReloadError())
+	assert.Nil(t, engines[1].Ready())
+	assert.Equal(t, 203, serve(engines[1], "GET", "/a", "").Code)
+
+	added := logs.FilterMessage("Server added but it requires a restart.").All()
+	require.Len(t, added, 1)
+	assert.Equal(t, "s2", added[0].ContextMap()["server"])
+	removed := logs.FilterMessageSnippet("Server removed but it requires a restart.").All()
+	require.Len(t, removed, 1)
+	assert.Equal(t, "s1", removed[0].ContextMap()["server"])
+}
@@ -27,11 +27,20 @@
 package engine
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"net/http"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
 	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
 )
 
@@ -42,13 +51,44 @@ var (
 	DEFAULT_CONTENT_TYPE string = "application/json"
 	// The default response. It is a pointer to an instance of DefaultResponse.
 	DEFAULT_RESPONSE = new(DefaultResponse)
+	// The identifier of the default response.
+	DEFAULT_RESPONSE_ID string = "default"
 )
 
+// Policy applied when the request body is larger than the maximum request size.
+type OverflowPolicy string
+
+const (
+	// Inherits the policy from the server configuration.
+	OVERFLOW_INHERIT OverflowPolicy = ""
+	// Truncates the captured body and flags the capture as truncated.
+	OVERFLOW_TRUNCATE OverflowPolicy = "truncate"
+	// Replies with 413 instead of the selected response.
+	OVERFLOW_REJECT OverflowPolicy = "reject"
+	// Saves the complete body into a sidecar file next to the capture.
+	OVERFLOW_SPOOL OverflowPolicy = "spool"
+)
+
+// Parses an overflow policy. The empty string is parsed as OVERFLOW_INHERIT.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(strings.ToLower(s)); p {
+	case OVERFLOW_INHERIT, OVERFLOW_TRUNCATE, OVERFLOW_REJECT, OVERFLOW_SPOOL:
+		return p, nil
+	default:
+		return OVERFLOW_INHERIT, fmt.Errorf("invalid body overflow policy '%s'", s)
+	}
+}
+
 // This is the interface for all responses.
 type Response interface {
+	// Returns the identifier of this response.
+	ID() string
 	// Checks if the given request matches with this response based on the
 	// method and path.
 	Match(method string, path string) bool
+	// Checks if the given request matches with this response. It checks the
+	// same conditions of Match() plus the ones that require the full request.
+	MatchRequest(request *http.Request) bool
 	// Returns the return code.
 	ResponseCode() int
 	// Returns the content type.
@@ -57,6 +97,8 @@ type Response interface {
 	WriteBody(writer io.Writer) error
 	// If true, prevents the request from being captured.
 	SkipCapture() bool
+	// Returns the policy for request bodies larger than the maximum request size.
+	OverflowPolicy() OverflowPolicy
 }
 
 //------------------------------------------------------------------------------
@@ -65,11 +107,21 @@ type Response interface {
 // will reply with 200 and an empty JSON object.
 type DefaultResponse struct{}
 
+// Always return DEFAULT_RESPONSE_ID.
+func (r *DefaultResponse) ID() string {
+	return DEFAULT_RESPONSE_ID
+}
+
 // Always return true.
 func (r *DefaultResponse) Match(method string, path string) bool {
 	return true
 }
 
+// Always return true.
+func (r *DefaultResponse) MatchRequest(request *http.Request) bool {
+	return true
+}
+
 // Always return 200.
 func (r *DefaultResponse) ResponseCode() int {
 	return 200
@@ -89,6 +141,11 @@ func (r *DefaultResponse) SkipCapture() bool {
 	return false
 }
 
+// Always return OVERFLOW_INHERIT.
+func (r *DefaultResponse) OverflowPolicy() OverflowPolicy {
+	return OVERFLOW_INHERIT
+}
+
 //------------------------------------------------------------------------------
 
 /*
@@ -96,12 +153,17 @@ Implementation of a Response that uses regular expressions to decide if it
 matches with the given request.
 */
 type responseImpl struct {
+	id           string
 	pathPattern  *regexp.Regexp
 	methods      map[string]bool
 	responseCode int
 	contentType  string
 	body         []byte
 	skipCapture  bool
+	overflow     OverflowPolicy
+	// Client certificate conditions.
+	clientCertSubject     *regexp.Regexp
+	clientCertFingerprint string
 }
 
 // Creates a new responseImpl and initializes some fields with default values.
@@ -116,6 +178,10 @@ func newResponseImpl() *responseImpl {
 	}
 }
 
+func (r *responseImpl) ID() string {
+	return r.id
+}
+
 // Checks if the given path matches this response.
 func (r *responseImpl) MatchPath(path string) bool {
 	if r.pathPattern == nil {
@@ -136,6 +202,32 @@ func (r *responseImpl) Match(method string, path string) bool {
 	return r.MatchMethods(method) && r.MatchPath(path)
 }
 
+// Checks if the client certificate of the connection matches this response.
+// If this response has no client certificate condition, it always matches.
+func (r *responseImpl) MatchClientCert(state *tls.ConnectionState) bool {
+	if r.clientCertSubject == nil && r.clientCertFingerprint == "" {
+		return true
+	}
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return false
+	}
+	cert := state.PeerCertificates[0]
+	if r.clientCertSubject != nil && !r.clientCertSubject.MatchString(cert.Subject.String()) {
+		return false
+	}
+	if r.clientCertFingerprint != "" {
+		sum := sha256.Sum256(cert.Raw)
+		if hex.EncodeToString(sum[:]) != r.clientCertFingerprint {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *responseImpl) MatchRequest(request *http.Request) bool {
+	return r.Match(request.Method, request.URL.Path) && r.MatchClientCert(request.TLS)
+}
+
 func (r *responseImpl) ResponseCode() int {
 	return r.responseCode
 }
@@ -156,16 +248,42 @@ func (r *responseImpl) SkipCapture() bool {
 	return r.skipCapture
 }
 
+func (r *responseImpl) OverflowPolicy() OverflowPolicy {
+	return r.overflow
+}
+
 // ------------------------------------------------------------------------------
 
 // This builder is used to create responses.
 type ResponseBuilder struct {
+	id           string
 	pathPattern  *regexp.Regexp
 	methods      []string
 	responseCode int
 	contentType  string
 	body         []byte
 	skipCapture  bool
+	overflow     OverflowPolicy
+	// Client certificate conditions.
+	clientCertSubject     *regexp.Regexp
+	clientCertFingerprint string
+}
+
+// Normalizes a SHA-256 fingerprint into lowercase hex without separators.
+func normalizeFingerprint(fingerprint string) (string, error) {
+	s := strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fingerprint))
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 fingerprint '%s'", fingerprint)
+	}
+	return s, nil
+}
+
+// Sets the identifier of the response. If not set, defaults to "".
+//
+// It always returns itself.
+func (b *ResponseBuilder) SetID(id string) *ResponseBuilder {
+	b.id = id
+	return b
 }
 
 // Sets the path pattern from a regex string.
@@ -229,10 +347,32 @@ func (b *ResponseBuilder) SetBodyNoClone(body []byte) *ResponseBuilder {
 	return b
 }
 
+// Sets the pattern matched against the subject of the client certificate, in
+// the format returned by pkix.Name.String(). If set, requests without a client
+// certificate never match.
+//
+// It always returns itself.
+func (b *ResponseBuilder) SetClientCertSubject(pattern *regexp.Regexp) *ResponseBuilder {
+	b.clientCertSubject = pattern
+	return b
+}
+
+// Sets the SHA-256 fingerprint of the client certificate. It accepts hex with
+// or without colons. If set, requests without a client certificate never match.
+func (b *ResponseBuilder) SetClientCertFingerprint(fingerprint string) error {
+	f, err := normalizeFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+	b.clientCertFingerprint = f
+	return nil
+}
+
 // Builds a new response based on the current builder state.
 func (b *ResponseBuilder) Build() Response {
 	r := newResponseImpl()
 
+	r.id = b.id
 	if b.pathPattern != nil {
 		r.pathPattern = b.pathPattern
 	}
@@ -247,6 +387,9 @@ func (b *ResponseBuilder) Build() Response {
 		r.body = append([]byte(nil), b.body...)
 	}
 	r.skipCapture = b.skipCapture
+	r.overflow = b.overflow
+	r.clientCertSubject = b.clientCertSubject
+	r.clientCertFingerprint = b.clientCertFingerprint
 	return r
 }
 
@@ -258,23 +401,125 @@ func (b *ResponseBuilder) SkipCapture(skipCapture bool) *ResponseBuilder {
 	return b
 }
 
+// Sets the body overflow policy. Defaults to OVERFLOW_INHERIT.
+//
+// It always returns itself.
+func (b *ResponseBuilder) SetOverflowPolicy(policy OverflowPolicy) *ResponseBuilder {
+	b.overflow = policy
+	return b
+}
+
 //------------------------------------------------------------------------------
 
 // This struct implements a response set. It stores a list of responses and implements
 // the response matching mechanism.
+//
+// It is safe for concurrent use. The list is never modified in place; all changes
+// replace it with a modified copy, thus readers may use it without holding the lock.
 type ResponseSet struct {
+	mutex     sync.RWMutex
 	responses []Response
 }
 
+// Returns the current list of responses. It must not be modified.
+func (s *ResponseSet) snapshot() []Response {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.responses
+}
+
+// Returns the index of the response with the given ID or -1. The caller must
+// hold the lock.
+func (s *ResponseSet) indexOf(id string) int {
+	for i, r := range s.responses {
+		if r.ID() == id {
+			return i
+		}
+	}
+	return -1
+}
+
 // Adds a response to this list. The first added
 func (s *ResponseSet) AddResponse(response Response) {
-	s.responses = append(s.responses, response)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.responses = append(s.responses[:len(s.responses):len(s.responses)], response)
+}
+
+// Inserts a response at the given position. If the position is out of range,
+// the response is added to the end of the list. Returns false if there is
+// another response with the same ID.
+func (s *ResponseSet) InsertResponse(index int, response Response) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.indexOf(response.ID()) >= 0 {
+		return false
+	}
+	if index < 0 || index > len(s.responses) {
+		index = len(s.responses)
+	}
+	responses := make([]Response, 0, len(s.responses)+1)
+	responses = append(responses, s.responses[:index]...)
+	responses = append(responses, response)
+	s.responses = append(responses, s.responses[index:]...)
+	return true
+}
+
+// Replaces the response that has the same ID of the given response. Returns
+// false if it does not exist.
+func (s *ResponseSet) ReplaceResponse(response Response) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i := s.indexOf(response.ID())
+	if i < 0 {
+		return false
+	}
+	responses := append([]Response(nil), s.responses...)
+	responses[i] = response
+	s.responses = responses
+	return true
+}
+
+// Removes the response with the given ID. Returns false if it does not exist.
+func (s *ResponseSet) RemoveResponse(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i := s.indexOf(id)
+	if i < 0 {
+		return false
+	}
+	responses := make([]Response, 0, len(s.responses)-1)
+	responses = append(responses, s.responses[:i]...)
+	s.responses = append(responses, s.responses[i+1:]...)
+	return true
+}
+
+// Replaces all responses at once.
+func (s *ResponseSet) SetResponses(responses []Response) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.responses = append([]Response(nil), responses...)
+}
+
+// Returns a copy of the list of responses.
+func (s *ResponseSet) Responses() []Response {
+	return append([]Response(nil), s.snapshot()...)
+}
+
+// Returns the response with the given ID or nil if it does not exist.
+func (s *ResponseSet) Get(id string) Response {
+	for _, r := range s.snapshot() {
+		if r.ID() == id {
+			return r
+		}
+	}
+	return nil
 }
 
 // Finds a response that matches the request. If no registered response matches it
 // returns DEFAULT_RESPONSE.
 func (s *ResponseSet) Find(method string, path string) Response {
-	for _, r := range s.responses {
+	for _, r := range s.snapshot() {
 		if r.Match(method, path) {
 			return r
 		}
@@ -282,6 +527,18 @@ func (s *ResponseSet) Find(method string, path string) Response {
 	return DEFAULT_RESPONSE
 }
 
+// Finds a response that matches the request, including the conditions that
+// require the full request. If no registered response matches it returns
+// DEFAULT_RESPONSE.
+func (s *ResponseSet) FindRequest(request *http.Request) Response {
+	for _, r := range s.snapshot() {
+		if r.MatchRequest(request) {
+			return r
+		}
+	}
+	return DEFAULT_RESPONSE
+}
+
 //------------------------------------------------------------------------------
 
 // Writes a response to a ResponseWriter.
@@ -293,9 +550,106 @@ func WriteResponse(resp Response, response http.ResponseWriter) error {
 	return resp.WriteBody(response)
 }
 
+// Error in the definition of the response at the given index.
+type ResponseConfigError struct {
+	Index int
+	Err   error
+}
+
+func (e *ResponseConfigError) Error() string {
+	return fmt.Sprintf("response %d: %v", e.Index, e.Err)
+}
+
+func (e *ResponseConfigError) Unwrap() error {
+	return e.Err
+}
+
+// Creates the responses defined by the configuration. Responses without ID
+// receive DefaultResponseID(). Invalid definitions and duplicated IDs are
+// skipped and reported in the returned errors.
+func NewResponsesFromConfig(configs []*config.ResponseConfig) ([]Response, []*ResponseConfigError) {
+	return newResponsesFromConfig(configs, DefaultResponseID)
+}
+
+// Same as NewResponsesFromConfig() but the default identifiers are created by
+// defaultID.
+func newResponsesFromConfig(configs []*config.ResponseConfig,
+	defaultID func(index int) string) ([]Response, []*ResponseConfigError) {
+	var responses []Response
+	var errs []*ResponseConfigError
+	ids := make(map[string]bool)
+	for i, cfg := range configs {
+		b, err := newBuilderFromConfig(cfg)
+		if err != nil {
+			errs = append(errs, &ResponseConfigError{Index: i, Err: err})
+			continue
+		}
+		if b.id == "" {
+			b.SetID(defaultID(i))
+		}
+		if ids[b.id] {
+			errs = append(errs, &ResponseConfigError{Index: i, Err: fmt.Errorf("duplicated id '%s'", b.id)})
+			continue
+		}
+		ids[b.id] = true
+		responses = append(responses, b.Build())
+	}
+	return responses, errs
+}
+
+// Returns the configuration equivalent to the given response.
+func ConfigFromResponse(response Response) *config.ResponseConfig {
+	body := bytes.NewBuffer(nil)
+	response.WriteBody(body)
+	ret := &config.ResponseConfig{
+		ID:           response.ID(),
+		ContentType:  response.ContentType(),
+		SkipCapture:  response.SkipCapture(),
+		ReturnCode:   response.ResponseCode(),
+		BodyOverflow: string(response.OverflowPolicy()),
+	}
+	if body.Len() > 0 {
+		ret.Body = base64.StdEncoding.EncodeToString(body.Bytes())
+	}
+	if r, ok := response.(*responseImpl); ok {
+		if r.pathPattern != nil {
+			ret.PathPattern = r.pathPattern.String()
+		}
+		for m := range r.methods {
+			ret.Methods = append(ret.Methods, m)
+		}
+		sort.Strings(ret.Methods)
+		if r.clientCertSubject != nil {
+			ret.ClientCertSubject = r.clientCertSubject.String()
+		}
+		if r.clientCertFingerprint != "" {
+			// Same format of the captures
+			sum, _ := hex.DecodeString(r.clientCertFingerprint)
+			ret.ClientCertFingerprint = capture.FormatFingerprint(sum)
+		}
+	}
+	return ret
+}
+
 // Creates a new response from the configuration.
 func NewResponseFromConfig(config *config.ResponseConfig) (Response, error) {
-	b := ResponseBuilder{}
+	b, err := newBuilderFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return b.Build(), nil
+}
+
+// Returns the identifier assigned to the response at the given index of the
+// configuration when it does not define one.
+func DefaultResponseID(index int) string {
+	return fmt.Sprintf("response-%d", index)
+}
+
+// Creates a new builder initialized with the configuration.
+func newBuilderFromConfig(config *config.ResponseConfig) (*ResponseBuilder, error) {
+	b := &ResponseBuilder{}
+	b.SetID(config.ID)
 	if config.PathPattern != "" {
 		p, err := regexp.Compile(config.PathPattern)
 		if err != nil {
@@ -314,9 +668,26 @@ func NewResponseFromConfig(config *config.ResponseConfig) (Response, error) {
 		}
 		b.SetBody(body)
 	}
+	if config.ClientCertSubject != "" {
+		p, err := regexp.Compile(config.ClientCertSubject)
+		if err != nil {
+			return nil, err
+		}
+		b.SetClientCertSubject(p)
+	}
+	if config.ClientCertFingerprint != "" {
+		if err := b.SetClientCertFingerprint(config.ClientCertFingerprint); err != nil {
+			return nil, err
+		}
+	}
 	b.SkipCapture(config.SkipCapture)
+	overflow, err := ParseOverflowPolicy(config.BodyOverflow)
+	if err != nil {
+		return nil, err
+	}
+	b.SetOverflowPolicy(overflow)
 	if config.ReturnCode != 0 {
 		b.SetResponseCode(config.ReturnCode)
 	}
-	return b.Build(), nil
+	return b, nil
 }
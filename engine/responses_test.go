@@ -28,12 +28,17 @@ package engine
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
 )
 
 //------------------------------------------------------------------------------
@@ -42,11 +47,13 @@ var _ Response = (*DefaultResponse)(nil)
 
 func TestDefaultResponse(t *testing.T) {
 
+	assert.Equal(t, "default", DEFAULT_RESPONSE.ID())
 	assert.Equal(t, int(200), DEFAULT_RESPONSE.ResponseCode())
 	assert.Equal(t, "application/json", DEFAULT_RESPONSE.ContentType())
 
 	assert.True(t, DEFAULT_RESPONSE.Match("", ""))
 	assert.True(t, DEFAULT_RESPONSE.Match("12312312 13", "13 1313123"))
+	assert.True(t, DEFAULT_RESPONSE.MatchRequest(httptest.NewRequest("GET", "/", nil)))
 
 	actual := bytes.NewBuffer(nil)
 	assert.Nil(t, DEFAULT_RESPONSE.WriteBody(actual))
@@ -121,6 +128,14 @@ func TestResponseImpl_Match(t *testing.T) {
 	assert.True(t, r.Match("POST", "/a"))
 }
 
+func TestResponseImpl_ID(t *testing.T) {
+	r := responseImpl{}
+
+	assert.Equal(t, "", r.ID())
+	r.id = "id1"
+	assert.Equal(t, "id1", r.ID())
+}
+
 func TestResponseImpl_ResponseCode(t *testing.T) {
 	r := responseImpl{}
 
@@ -152,6 +167,14 @@ func TestResponseImpl_WriteBody(t *testing.T) {
 
 // ------------------------------------------------------------------------------
 
+func TestResponseBuilder_SetID(t *testing.T) {
+	b := ResponseBuilder{}
+
+	b2 := b.SetID("id1")
+	assert.Same(t, &b, b2)
+	assert.Equal(t, "id1", b.id)
+}
+
 func TestResponseBuilder_SetPathPatternStr(t *testing.T) {
 	b := ResponseBuilder{}
 
@@ -235,10 +258,11 @@ func TestResponseBuilder_Build(t *testing.T) {
 	body := []byte("12345")
 
 	b = ResponseBuilder{}
-	b.SetPathPattern(pattern).AddMethod("PUT", "POST", "PUT").SetResponseCode(123).SetContentType("type1").SetBody(body)
+	b.SetID("id1").SetPathPattern(pattern).AddMethod("PUT", "POST", "PUT").SetResponseCode(123).SetContentType("type1").SetBody(body)
 	r = b.Build()
 	imp = r.(*responseImpl)
 	require.NotNil(t, imp)
+	assert.Equal(t, "id1", imp.id)
 	assert.Same(t, pattern, imp.pathPattern)
 	assert.Len(t, imp.methods, 2)
 	assert.Contains(t, imp.methods, "PUT")
@@ -316,6 +340,57 @@ func TestResponseSet_Find(t *testing.T) {
 	assert.Same(t, r3, r)
 }
 
+func TestResponseSet_Changes(t *testing.T) {
+	s := ResponseSet{}
+
+	b := ResponseBuilder{}
+	r1 := b.SetID("1").Build()
+	r2 := b.SetID("2").Build()
+	r3 := b.SetID("3").Build()
+	r2b := b.SetID("2").SetResponseCode(201).Build()
+
+	assert.True(t, s.InsertResponse(-1, r1))
+	assert.True(t, s.InsertResponse(0, r2))
+	assert.True(t, s.InsertResponse(1, r3))
+	assert.False(t, s.InsertResponse(1, r3))
+	assert.Equal(t, []Response{r2, r3, r1}, s.Responses())
+
+	old := s.Responses()
+	assert.True(t, s.ReplaceResponse(r2b))
+	assert.False(t, s.ReplaceResponse(b.SetID("4").Build()))
+	assert.Equal(t, []Response{r2b, r3, r1}, s.Responses())
+	assert.Equal(t, []Response{r2, r3, r1}, old)
+	assert.Same(t, r2b, s.Get("2"))
+	assert.Nil(t, s.Get("4"))
+
+	assert.True(t, s.RemoveResponse("3"))
+	assert.False(t, s.RemoveResponse("3"))
+	assert.Equal(t, []Response{r2b, r1}, s.Responses())
+
+	s.SetResponses([]Response{r3})
+	assert.Equal(t, []Response{r3}, s.Responses())
+	s.SetResponses(nil)
+	assert.Same(t, DEFAULT_RESPONSE, s.Find("GET", "/"))
+}
+
+func TestResponseSet_Concurrency(t *testing.T) {
+	s := ResponseSet{}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			b := ResponseBuilder{}
+			s.AddResponse(b.SetID(fmt.Sprint(i)).AddMethod("PUT").Build())
+			s.RemoveResponse(fmt.Sprint(i - 1))
+		}
+		done <- true
+	}()
+	for i := 0; i < 1000; i++ {
+		assert.Same(t, DEFAULT_RESPONSE, s.Find("GET", "/"))
+	}
+	<-done
+	assert.Len(t, s.Responses(), 1)
+}
+
 //------------------------------------------------------------------------------
 
 func TestWriteResponse(t *testing.T) {
@@ -335,3 +410,146 @@ func TestWriteResponse(t *testing.T) {
 	assert.Equal(t, "", resp.Header().Get("Content-Type"))
 	assert.Equal(t, "", resp.Body.String())
 }
+
+func TestNewResponseFromConfig(t *testing.T) {
+	r, err := NewResponseFromConfig(&config.ResponseConfig{
+		ID:           "id1",
+		PathPattern:  "^/a$",
+		Methods:      []string{"GET"},
+		ContentType:  "text/plain",
+		Body:         "MTIz",
+		ReturnCode:   201,
+		BodyOverflow: "Reject",
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "id1", r.ID())
+	assert.Equal(t, OVERFLOW_REJECT, r.OverflowPolicy())
+	assert.True(t, r.Match("GET", "/a"))
+	assert.False(t, r.Match("POST", "/a"))
+	assert.Equal(t, 201, r.ResponseCode())
+	assert.Equal(t, "text/plain", r.ContentType())
+
+	_, err = NewResponseFromConfig(&config.ResponseConfig{PathPattern: "["})
+	assert.NotNil(t, err)
+
+	_, err = NewResponseFromConfig(&config.ResponseConfig{Body: "!"})
+	assert.NotNil(t, err)
+
+	_, err = NewResponseFromConfig(&config.ResponseConfig{BodyOverflow: "x"})
+	assert.NotNil(t, err)
+
+	_, err = NewResponseFromConfig(&config.ResponseConfig{ClientCertSubject: "["})
+	assert.NotNil(t, err)
+
+	_, err = NewResponseFromConfig(&config.ResponseConfig{ClientCertFingerprint: "x"})
+	assert.NotNil(t, err)
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for s, exp := range map[string]OverflowPolicy{
+		"":         OVERFLOW_INHERIT,
+		"truncate": OVERFLOW_TRUNCATE,
+		"REJECT":   OVERFLOW_REJECT,
+		"spool":    OVERFLOW_SPOOL,
+	} {
+		p, err := ParseOverflowPolicy(s)
+		assert.Nil(t, err)
+		assert.Equal(t, exp, p)
+	}
+	_, err := ParseOverflowPolicy("drop")
+	assert.ErrorContains(t, err, "invalid body overflow policy 'drop'")
+}
+
+func TestDefaultResponseID(t *testing.T) {
+	assert.Equal(t, "response-0", DefaultResponseID(0))
+	assert.Equal(t, "response-12", DefaultResponseID(12))
+}
+
+func TestNewResponsesFromConfig(t *testing.T) {
+	responses, errs := NewResponsesFromConfig([]*config.ResponseConfig{
+		{PathPattern: "^/a$"},
+		{PathPattern: "["},
+		{ID: "x"},
+		{ID: "x"},
+		{},
+	})
+	require.Len(t, responses, 3)
+	assert.Equal(t, "response-0", responses[0].ID())
+	assert.Equal(t, "x", responses[1].ID())
+	assert.Equal(t, "response-4", responses[2].ID())
+	require.Len(t, errs, 2)
+	assert.Equal(t, 1, errs[0].Index)
+	assert.Equal(t, 3, errs[1].Index)
+	assert.Equal(t, "response 3: duplicated id 'x'", errs[1].Error())
+}
+
+func TestConfigFromResponse(t *testing.T) {
+	exp := &config.ResponseConfig{
+		ID:                    "id1",
+		PathPattern:           "^/a$",
+		Methods:               []string{"GET", "POST"},
+		ContentType:           "text/plain",
+		Body:                  "MTIz",
+		SkipCapture:           true,
+		ReturnCode:            201,
+		BodyOverflow:          "spool",
+		ClientCertSubject:     "CN=client",
+		ClientCertFingerprint: strings.Repeat("0a", 32),
+	}
+	r, err := NewResponseFromConfig(exp)
+	require.Nil(t, err)
+	// The fingerprint is written in the format of the captures
+	exp.ClientCertFingerprint = strings.Repeat("0A:", 31) + "0A"
+	assert.Equal(t, exp, ConfigFromResponse(r))
+
+	assert.Equal(t, &config.ResponseConfig{
+		ID:          "default",
+		ContentType: "application/json",
+		Body:        "e30=",
+		ReturnCode:  200,
+	}, ConfigFromResponse(DEFAULT_RESPONSE))
+}
+
+func TestResponseImpl_MatchClientCert(t *testing.T) {
+	cert := newTestClientCertificate(t, "client").Leaf
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	fingerprint := Fingerprint(cert)
+
+	r := (&ResponseBuilder{}).Build().(*responseImpl)
+	assert.True(t, r.MatchClientCert(nil))
+	assert.True(t, r.MatchClientCert(state))
+
+	b := &ResponseBuilder{}
+	b.SetClientCertSubject(regexp.MustCompile("CN=client"))
+	r = b.Build().(*responseImpl)
+	assert.False(t, r.MatchClientCert(nil))
+	assert.False(t, r.MatchClientCert(&tls.ConnectionState{}))
+	assert.True(t, r.MatchClientCert(state))
+	b.SetClientCertSubject(regexp.MustCompile("CN=other"))
+	assert.False(t, b.Build().(*responseImpl).MatchClientCert(state))
+
+	b = &ResponseBuilder{}
+	require.Nil(t, b.SetClientCertFingerprint(fingerprint))
+	assert.True(t, b.Build().(*responseImpl).MatchClientCert(state))
+	require.Nil(t, b.SetClientCertFingerprint(strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))))
+	assert.True(t, b.Build().(*responseImpl).MatchClientCert(state))
+	require.Nil(t, b.SetClientCertFingerprint(strings.Repeat("00", 32)))
+	assert.False(t, b.Build().(*responseImpl).MatchClientCert(state))
+	assert.ErrorContains(t, b.SetClientCertFingerprint("00:11"), "invalid SHA-256 fingerprint '00:11'")
+	assert.ErrorContains(t, b.SetClientCertFingerprint(strings.Repeat("zz", 32)), "invalid SHA-256 fingerprint")
+}
+
+func TestResponseSet_FindRequest(t *testing.T) {
+	cert := newTestClientCertificate(t, "client").Leaf
+	var s ResponseSet
+	b := &ResponseBuilder{}
+	b.SetID("mtls").SetClientCertSubject(regexp.MustCompile("^CN=client$"))
+	s.AddResponse(b.Build())
+	s.AddResponse((&ResponseBuilder{}).SetID("get").AddMethod("GET").Build())
+
+	r := httptest.NewRequest("GET", "/a", nil)
+	assert.Equal(t, "get", s.FindRequest(r).ID())
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	assert.Equal(t, "mtls", s.FindRequest(r).ID())
+	assert.Equal(t, DEFAULT_RESPONSE, s.FindRequest(httptest.NewRequest("POST", "/a", nil)))
+}
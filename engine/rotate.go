@@ -0,0 +1,167 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+File that is rotated when it reaches a maximum size. The rotated files receive
+the suffixes ".1", ".2" and so on, ".1" being the most recent one. Only
+maxBackups rotated files are kept.
+*/
+type RotatingFile struct {
+	mutex      sync.Mutex
+	name       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	closed     bool
+	// Set while the rotation fails, so the failure is reported only once.
+	rotateErr error
+}
+
+/*
+Opens the given file for appending. A maxSize of zero disables the rotation. At
+least one rotated file is always kept.
+*/
+func OpenRotatingFile(name string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if maxBackups < 1 {
+		maxBackups = 1
+	}
+	ret := &RotatingFile{name: name, maxSize: maxSize, maxBackups: maxBackups}
+	if err := ret.open(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = stat.Size()
+	return nil
+}
+
+// Name of the file.
+func (f *RotatingFile) Name() string {
+	return f.name
+}
+
+/*
+Rotates the file. The file is reopened even if the rotation fails, so the
+writes can continue on the original file and the rotation is retried later.
+*/
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err == nil {
+		os.Remove(fmt.Sprintf("%s.%d", f.name, f.maxBackups))
+		for i := f.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.name, i), fmt.Sprintf("%s.%d", f.name, i+1))
+		}
+		err = os.Rename(f.name, f.name+".1")
+	}
+	if openErr := f.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+/*
+Writes p into the file, rotating it first if p does not fit. If the rotation
+fails, p is still written into the original file and the rotation is retried on
+the next write that does not fit. The failure is reported once, until a
+rotation succeeds.
+*/
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.file == nil {
+		// A previous rotation could not reopen the file.
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	var rotateErr error
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err == nil {
+			f.rotateErr = nil
+		} else if f.file == nil {
+			return 0, err
+		} else if f.rotateErr == nil {
+			f.rotateErr = err
+			rotateErr = fmt.Errorf("unable to rotate '%s': %w", f.name, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rotateErr
+}
+
+func (f *RotatingFile) Sync() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	name := path.Join(t.TempDir(), "test.log")
+	require.Nil(t, os.WriteFile(name, []byte("0123"), 0644))
+
+	f, err := OpenRotatingFile(name, 10, 2)
+	require.Nil(t, err)
+	assert.Equal(t, name, f.Name())
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.Nil(t, err)
+		return string(data)
+	}
+
+	// Appends to the existing file
+	n, err := f.Write([]byte("456"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "0123456", read(name))
+
+	// Rotates
+	_, err = f.Write([]byte("abcd"))
+	assert.Nil(t, err)
+	assert.Equal(t, "abcd", read(name))
+	assert.Equal(t, "0123456", read(name+".1"))
+
+	// Entries larger than the limit are written into an empty file
+	_, err = f.Write([]byte("0123456789ABC"))
+	assert.Nil(t, err)
+	_, err = f.Write([]byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, "x", read(name))
+	assert.Equal(t, "0123456789ABC", read(name+".1"))
+	assert.Equal(t, "abcd", read(name+".2"))
+	assert.NoFileExists(t, name+".3")
+
+	assert.Nil(t, f.Sync())
+	assert.Nil(t, f.Close())
+	assert.Nil(t, f.Close())
+	_, err = f.Write([]byte("y"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestRotatingFile_RenameError(t *testing.T) {
+	name := path.Join(t.TempDir(), "test.log")
+	f, err := OpenRotatingFile(name, 10, 1)
+	require.Nil(t, err)
+	defer f.Close()
+	_, err = f.Write([]byte("0123456"))
+	require.Nil(t, err)
+
+	// A non-empty directory cannot be removed nor replaced by the rename
+	require.Nil(t, os.MkdirAll(path.Join(name+".1", "dir"), 0755))
+	n, err := f.Write([]byte("abcd"))
+	assert.ErrorContains(t, err, "unable to rotate")
+	assert.Equal(t, 4, n)
+
+	// The entries are still written into the original file and the failure is
+	// reported once
+	n, err = f.Write([]byte("789"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	data, err := os.ReadFile(name)
+	require.Nil(t, err)
+	assert.Equal(t, "0123456abcd789", string(data))
+	assert.Nil(t, f.Sync())
+
+	// The rotation is retried on the next write
+	require.Nil(t, os.RemoveAll(name+".1"))
+	_, err = f.Write([]byte("x"))
+	assert.Nil(t, err)
+	data, err = os.ReadFile(name)
+	require.Nil(t, err)
+	assert.Equal(t, "x", string(data))
+	data, err = os.ReadFile(name + ".1")
+	require.Nil(t, err)
+	assert.Equal(t, "0123456abcd789", string(data))
+
+	// A new failure is reported again
+	require.Nil(t, os.Remove(name+".1"))
+	require.Nil(t, os.MkdirAll(path.Join(name+".1", "dir"), 0755))
+	_, err = f.Write([]byte("0123456789"))
+	assert.ErrorContains(t, err, "unable to rotate")
+}
+
+func TestRotatingFile_NoRotation(t *testing.T) {
+	name := path.Join(t.TempDir(), "test.log")
+	f, err := OpenRotatingFile(name, 0, 0)
+	require.Nil(t, err)
+	defer f.Close()
+	for i := 0; i < 10; i++ {
+		_, err = f.Write([]byte("0123456789"))
+		require.Nil(t, err)
+	}
+	stat, err := os.Stat(name)
+	require.Nil(t, err)
+	assert.Equal(t, int64(100), stat.Size())
+	assert.NoFileExists(t, name+".1")
+}
+
+func TestOpenRotatingFile_Error(t *testing.T) {
+	_, err := OpenRotatingFile(path.Join(t.TempDir(), "missing", "test.log"), 0, 0)
+	assert.NotNil(t, err)
+}
@@ -27,11 +27,15 @@
 package engine
 
 import (
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+	"go.uber.org/zap"
 )
 
 func CheckConfig(config *config.Config) error {
@@ -47,17 +51,90 @@ func CheckConfig(config *config.Config) error {
 	return nil
 }
 
+// Fails if the configuration file has any problem.
+func checkStrict(configFile string) error {
+	problems, err := ValidateConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	errs := []error{fmt.Errorf("the configuration has %d problem(s) and strict is set", len(problems))}
+	for _, p := range problems {
+		errs = append(errs, p)
+	}
+	return errors.Join(errs...)
+}
+
 func StartServer(configFile string) error {
 	cfg, err := config.LoadConfig(configFile)
 	if err != nil {
 		return err
 	}
-	if err := CheckConfig(cfg); err != nil {
-		return err
+	if cfg.Strict {
+		if err := checkStrict(configFile); err != nil {
+			return err
+		}
 	}
-	engine, err := NewEngine(cfg)
+	configs, err := cfg.ServerConfigs()
 	if err != nil {
 		return err
 	}
-	return engine.StartServer()
+	var engines []*Engine
+	for _, c := range configs {
+		if err := CheckConfig(c); err != nil {
+			return err
+		}
+		var options []EngineOption
+		if len(engines) > 0 {
+			// The logger and the access log of the main server are shared by all servers
+			options = append(options, WithLogger(engines[0].Logger.With(zap.String("server", c.Name))),
+				WithAccessLog(engines[0].accessLog))
+		}
+		engine, err := NewEngine(c, options...)
+		if err != nil {
+			return fmt.Errorf("server '%s': %w", c.Name, err)
+		}
+		if auto := engine.AutoCertificates; auto != nil {
+			fmt.Printf("CA certificate: %s\nCA fingerprint (SHA-256): %s\n", auto.CACertFile, auto.CAFingerprint)
+			engine.Logger.Info("Using automatic certificates.", zap.String("ca", auto.CACertFile),
+				zap.String("fingerprint", auto.CAFingerprint))
+		}
+		engines = append(engines, engine)
+	}
+	return StartServers(engines...)
+}
+
+/*
+Writes the automatic CA certificate defined by the given configuration file into
+out. The format may be "pem" or "der". The certificates are created if they do
+not exist yet.
+*/
+func ExportAutoCA(configFile string, format string, out io.Writer) (*AutoCertificates, error) {
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	auto, err := EnsureAutoCertificates(AutoCertDir(cfg), AutoCertHosts(cfg))
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(auto.CACertFile)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(format) {
+	case "pem":
+	case "der":
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("'%s' is not a PEM certificate", auto.CACertFile)
+		}
+		data = block.Bytes
+	default:
+		return nil, fmt.Errorf("invalid certificate format '%s'", format)
+	}
+	_, err = out.Write(data)
+	return auto, err
 }
@@ -0,0 +1,94 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// Counters of the requests handled by an engine. The admin API is not counted.
+type Stats struct {
+	// Requests received.
+	Requests int64 `json:"requests"`
+	// Requests being handled.
+	InFlight int64 `json:"inFlight"`
+	// Captures saved.
+	Captures int64 `json:"captures"`
+	// Requests that could not be captured.
+	CaptureErrors int64 `json:"captureErrors"`
+	// Responses that could not be sent.
+	ResponseErrors int64 `json:"responseErrors"`
+}
+
+// The counters behind Stats.
+type engineStats struct {
+	requests       atomic.Int64
+	inFlight       atomic.Int64
+	captures       atomic.Int64
+	captureErrors  atomic.Int64
+	responseErrors atomic.Int64
+}
+
+// Returns the current counters of the engine.
+func (e *Engine) Stats() Stats {
+	return Stats{
+		Requests:       e.stats.requests.Load(),
+		InFlight:       e.stats.inFlight.Load(),
+		Captures:       e.stats.captures.Load(),
+		CaptureErrors:  e.stats.captureErrors.Load(),
+		ResponseErrors: e.stats.responseErrors.Load(),
+	}
+}
+
+/*
+Waits until all requests being handled finish or the context is done. Returns
+false if the context was done first.
+*/
+func (e *Engine) waitInFlight(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		e.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Logs the counters of the engine.
+func (e *Engine) logStats() {
+	stats := e.Stats()
+	e.Logger.Info("Shutdown summary.", zap.Int64("requests", stats.Requests),
+		zap.Int64("captures", stats.Captures), zap.Int64("captureErrors", stats.CaptureErrors),
+		zap.Int64("responseErrors", stats.ResponseErrors), zap.Int64("inFlight", stats.InFlight))
+}
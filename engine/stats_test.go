@@ -0,0 +1,135 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+	"go.uber.org/zap"
+)
+
+// A store that blocks Save() until release is closed.
+type blockingStore struct {
+	*capture.MemoryStore
+	release chan struct{}
+}
+
+func (s *blockingStore) Save(r *capture.CapturedRequest) error {
+	<-s.release
+	return s.MemoryStore.Save(r)
+}
+
+func TestEngine_Stats(t *testing.T) {
+	e := newTestEngine(t, &config.Config{
+		Admin: config.AdminConfig{Enabled: true, Prefix: "/_admin"},
+		Responses: []*config.ResponseConfig{
+			{PathPattern: "^/b$", SkipCapture: true},
+		},
+	})
+	serve(e, "GET", "/a", "")
+	serve(e, "GET", "/b", "")
+	serve(e, "GET", "/_admin/captures", "")
+	assert.Equal(t, Stats{Requests: 2, Captures: 1}, e.Stats())
+
+	e.Captures = &capture.DirStore{Dir: "6e3fe6ab7c4b31333e9ec2c3c602b308"}
+	serve(e, "GET", "/a", "")
+	assert.Equal(t, Stats{Requests: 3, Captures: 1, CaptureErrors: 1}, e.Stats())
+}
+
+// Starts the engine in a new server and sends a request while the store is
+// blocked. Returns the server once the request is being handled.
+func startBlockedRequest(t *testing.T, store *blockingStore) (*Engine, *http.Server) {
+	e, err := NewEngine(&config.Config{Address: "127.0.0.1:0", CaptureDir: t.TempDir()},
+		WithStore(store), WithLogger(zap.NewNop()))
+	require.Nil(t, err)
+	srv := e.servers()[0]
+	l, err := e.listen(srv.Addr)
+	require.Nil(t, err)
+	go srv.Serve(l)
+	go http.Get("http://" + l.Addr().String() + "/a")
+	require.Eventually(t, func() bool { return e.Stats().InFlight == 1 }, time.Second, time.Millisecond)
+	return e, srv
+}
+
+func TestShutdown(t *testing.T) {
+	// The request finishes within the timeout
+	store := &blockingStore{MemoryStore: capture.NewMemoryStore(), release: make(chan struct{})}
+	e, srv := startBlockedRequest(t, store)
+	time.AfterFunc(50*time.Millisecond, func() { close(store.release) })
+	Shutdown(5*time.Second, []*http.Server{srv}, []*Engine{e})
+	assert.Equal(t, Stats{Requests: 1, Captures: 1}, e.Stats())
+
+	// The timeout expires but the capture is still saved
+	store = &blockingStore{MemoryStore: capture.NewMemoryStore(), release: make(chan struct{})}
+	e, srv = startBlockedRequest(t, store)
+	time.AfterFunc(150*time.Millisecond, func() { close(store.release) })
+	start := time.Now()
+	Shutdown(100*time.Millisecond, []*http.Server{srv}, []*Engine{e})
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Equal(t, int64(1), e.Stats().Captures)
+	assert.Equal(t, int64(0), e.Stats().InFlight)
+	caps, err := store.List(nil)
+	require.Nil(t, err)
+	assert.Len(t, caps, 1)
+
+	// Without drain timeout, the capture is still saved
+	store = &blockingStore{MemoryStore: capture.NewMemoryStore(), release: make(chan struct{})}
+	e, srv = startBlockedRequest(t, store)
+	time.AfterFunc(50*time.Millisecond, func() { close(store.release) })
+	Shutdown(0, []*http.Server{srv}, []*Engine{e})
+	assert.Equal(t, int64(1), e.Stats().Captures)
+}
+
+func TestShutdown_SharedDeadline(t *testing.T) {
+	defer func(timeout time.Duration) { CAPTURE_FLUSH_TIMEOUT = timeout }(CAPTURE_FLUSH_TIMEOUT)
+	CAPTURE_FLUSH_TIMEOUT = 100 * time.Millisecond
+
+	// Requests that never finish
+	var servers []*http.Server
+	var engines []*Engine
+	for i := 0; i < 3; i++ {
+		store := &blockingStore{MemoryStore: capture.NewMemoryStore(), release: make(chan struct{})}
+		t.Cleanup(func() { close(store.release) })
+		e, srv := startBlockedRequest(t, store)
+		servers = append(servers, srv)
+		engines = append(engines, e)
+	}
+	start := time.Now()
+	Shutdown(100*time.Millisecond, servers, engines)
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 200*time.Millisecond)
+	assert.Less(t, elapsed, 400*time.Millisecond)
+	for _, e := range engines {
+		assert.Equal(t, int64(1), e.Stats().InFlight)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+const (
+	// ALPN identifier of HTTP/2 over TLS.
+	HTTP2_PROTO = "h2"
+	// ALPN identifier of HTTP/1.1.
+	HTTP1_PROTO = "http/1.1"
+)
+
+// Parses a TLS version in the format "1.x".
+func ParseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("invalid TLS version '%s'", s)
+	}
+}
+
+// Returns the ID of the cipher suite with the given name.
+func ParseCipherSuite(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite '%s'", name)
+}
+
+/*
+Returns the ALPN protocols of the server. If none is configured, it returns
+"h2" and "http/1.1". If HTTP1Only is set, "h2" is always removed.
+*/
+func NextProtos(cfg *config.TLSConfig) []string {
+	protos := cfg.ALPN
+	if len(protos) == 0 {
+		protos = []string{HTTP2_PROTO, HTTP1_PROTO}
+	}
+	var ret []string
+	for _, p := range protos {
+		if !(cfg.HTTP1Only && p == HTTP2_PROTO) {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// Parses a client certificate policy. The empty string is parsed as "none".
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("invalid client authentication mode '%s'", s)
+	}
+}
+
+// Loads a CA bundle in PEM format.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("'%s' does not contain any PEM certificate", file)
+	}
+	return pool, nil
+}
+
+/*
+Creates the TLS configuration of the server. It returns nil if TLS is disabled.
+*/
+func NewTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	ret := &tls.Config{}
+	var err error
+	if ret.MinVersion, err = ParseTLSVersion(cfg.MinVersion); err != nil {
+		return nil, err
+	}
+	for _, name := range cfg.CipherSuites {
+		id, err := ParseCipherSuite(name)
+		if err != nil {
+			return nil, err
+		}
+		ret.CipherSuites = append(ret.CipherSuites, id)
+	}
+	ret.NextProtos = NextProtos(cfg)
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("the TLS certificate and key files are required")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	ret.Certificates = []tls.Certificate{cert}
+	if ret.ClientAuth, err = ParseClientAuth(cfg.ClientAuth); err != nil {
+		return nil, err
+	}
+	if cfg.ClientCAFile != "" {
+		if ret.ClientCAs, err = loadCertPool(cfg.ClientCAFile); err != nil {
+			return nil, err
+		}
+	} else if ret.ClientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("the client CA file is required to verify the client certificates")
+	}
+	return ret, nil
+}
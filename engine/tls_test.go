@@ -0,0 +1,353 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/capture"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+// Writes a self-signed certificate for localhost and its key into dir.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.Nil(t, err)
+	certFile := path.Join(dir, "cert.pem")
+	keyFile := path.Join(dir, "key.pem")
+	require.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+// Creates a client certificate issued by a new CA. The CA is saved as ca.pem
+// inside the directory of the test.
+func newTestClientCertificate(t *testing.T, commonName string) tls.Certificate {
+	dir := t.TempDir()
+	ca, caKey, err := newAutoCA(path.Join(dir, "ca.pem"), path.Join(dir, "ca-key.pem"))
+	require.Nil(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.Nil(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	for s, exp := range map[string]uint16{
+		"":    tls.VersionTLS12,
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	} {
+		v, err := ParseTLSVersion(s)
+		assert.Nil(t, err)
+		assert.Equal(t, exp, v)
+	}
+	_, err := ParseTLSVersion("2")
+	assert.ErrorContains(t, err, "invalid TLS version '2'")
+}
+
+func TestParseCipherSuite(t *testing.T) {
+	id, err := ParseCipherSuite("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+	assert.Nil(t, err)
+	assert.Equal(t, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, id)
+
+	id, err = ParseCipherSuite("TLS_RSA_WITH_RC4_128_SHA")
+	assert.Nil(t, err)
+	assert.Equal(t, tls.TLS_RSA_WITH_RC4_128_SHA, id)
+
+	_, err = ParseCipherSuite("X")
+	assert.ErrorContains(t, err, "unknown cipher suite 'X'")
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	c, err := NewTLSConfig(&config.TLSConfig{})
+	assert.Nil(t, err)
+	assert.Nil(t, c)
+
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	c, err = NewTLSConfig(&config.TLSConfig{
+		Enabled:      true,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_AES_128_GCM_SHA256"},
+		ALPN:         []string{"http/1.1"},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), c.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_AES_128_GCM_SHA256}, c.CipherSuites)
+	assert.Equal(t, []string{"http/1.1"}, c.NextProtos)
+	assert.Len(t, c.Certificates, 1)
+
+	_, err = NewTLSConfig(&config.TLSConfig{Enabled: true, MinVersion: "x"})
+	assert.NotNil(t, err)
+	_, err = NewTLSConfig(&config.TLSConfig{Enabled: true, CipherSuites: []string{"x"}})
+	assert.NotNil(t, err)
+	_, err = NewTLSConfig(&config.TLSConfig{Enabled: true})
+	assert.ErrorContains(t, err, "the TLS certificate and key files are required")
+	_, err = NewTLSConfig(&config.TLSConfig{Enabled: true, CertFile: keyFile, KeyFile: certFile})
+	assert.NotNil(t, err)
+}
+
+func TestNextProtos(t *testing.T) {
+	assert.Equal(t, []string{"h2", "http/1.1"}, NextProtos(&config.TLSConfig{}))
+	assert.Equal(t, []string{"http/1.1"}, NextProtos(&config.TLSConfig{HTTP1Only: true}))
+	assert.Equal(t, []string{"http/1.1", "h2"}, NextProtos(&config.TLSConfig{ALPN: []string{"http/1.1", "h2"}}))
+	assert.Equal(t, []string{"http/1.1"}, NextProtos(&config.TLSConfig{ALPN: []string{"h2", "http/1.1"},
+		HTTP1Only: true}))
+}
+
+func TestParseClientAuth(t *testing.T) {
+	for s, exp := range map[string]tls.ClientAuthType{
+		"":        tls.NoClientCert,
+		"none":    tls.NoClientCert,
+		"Request": tls.RequestClientCert,
+		"require": tls.RequireAndVerifyClientCert,
+	} {
+		v, err := ParseClientAuth(s)
+		assert.Nil(t, err)
+		assert.Equal(t, exp, v)
+	}
+	_, err := ParseClientAuth("x")
+	assert.ErrorContains(t, err, "invalid client authentication mode 'x'")
+}
+
+func TestNewTLSConfig_ClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+	c, err := NewTLSConfig(&config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile,
+		ClientAuth: "request"})
+	require.Nil(t, err)
+	assert.Equal(t, tls.RequestClientCert, c.ClientAuth)
+	assert.Nil(t, c.ClientCAs)
+
+	c, err = NewTLSConfig(&config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile,
+		ClientAuth: "require", ClientCAFile: certFile})
+	require.Nil(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, c.ClientAuth)
+	assert.NotNil(t, c.ClientCAs)
+
+	_, err = NewTLSConfig(&config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile,
+		ClientAuth: "require"})
+	assert.ErrorContains(t, err, "the client CA file is required")
+	_, err = NewTLSConfig(&config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile,
+		ClientAuth: "x"})
+	assert.NotNil(t, err)
+	_, err = NewTLSConfig(&config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile,
+		ClientAuth: "require", ClientCAFile: keyFile})
+	assert.ErrorContains(t, err, "does not contain any PEM certificate")
+}
+
+func TestEngine_ServeHTTPS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	e := newTestEngine(t, &config.Config{
+		TLS: config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2"},
+	})
+	require.NotNil(t, e.tlsConfig)
+
+	srv := httptest.NewUnstartedServer(e)
+	srv.TLS = e.tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	pem, err := os.ReadFile(certFile)
+	require.Nil(t, err)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"},
+	}}
+	resp, err := client.Get(srv.URL + "/a")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	caps := loadCaptures(t, e)
+	require.Len(t, caps, 1)
+	require.NotNil(t, caps[0].TLS)
+	assert.Equal(t, "TLS 1.3", caps[0].TLS.Version)
+	assert.Equal(t, "localhost", caps[0].TLS.ServerName)
+}
+
+func TestEngine_ServeMutualTLS(t *testing.T) {
+	client := newTestClientCertificate(t, "client")
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	e := newTestEngine(t, &config.Config{
+		TLS: config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile, ClientAuth: "request"},
+		Responses: []*config.ResponseConfig{
+			{ID: "mtls", ClientCertSubject: "^CN=client$", ReturnCode: 202},
+		},
+	})
+
+	srv := httptest.NewUnstartedServer(e)
+	srv.TLS = e.tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	pem, err := os.ReadFile(certFile)
+	require.Nil(t, err)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+	get := func(certs ...tls.Certificate) int {
+		c := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "localhost", Certificates: certs},
+		}}
+		resp, err := c.Get(srv.URL + "/a")
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, 202, get(client))
+	assert.Equal(t, 200, get())
+
+	caps := loadCaptures(t, e)
+	require.Len(t, caps, 2)
+	var withCert *capture.CapturedRequest
+	for _, c := range caps {
+		if c.RuleID == "mtls" {
+			withCert = c
+		} else {
+			assert.Empty(t, c.TLS.ClientCertificates)
+		}
+	}
+	require.NotNil(t, withCert)
+	require.Len(t, withCert.TLS.ClientCertificates, 1)
+	assert.Equal(t, "CN=client", withCert.TLS.ClientCertificates[0].Subject)
+	assert.Equal(t, Fingerprint(client.Leaf), withCert.TLS.ClientCertificates[0].SHA256)
+	assert.False(t, withCert.TLS.ClientVerified)
+}
+
+// Starts a TLS test server configured by the engine. The returned client trusts
+// the server certificate and attempts HTTP/2.
+func startTLSTestServer(t *testing.T, e *Engine) (*httptest.Server, *http.Client) {
+	srv := httptest.NewUnstartedServer(e)
+	e.configureTLS(srv.Config)
+	srv.TLS = e.tlsConfig
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, ServerName: "localhost"},
+		ForceAttemptHTTP2: true,
+	}}
+	return srv, client
+}
+
+func TestEngine_ServeHTTP2(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	e := newTestEngine(t, &config.Config{
+		TLS: config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile},
+		Responses: []*config.ResponseConfig{
+			{PathPattern: "^/a$", Body: "MTIz", ContentType: "text/plain", ReturnCode: 201},
+		},
+	})
+	srv, client := startTLSTestServer(t, e)
+
+	request, err := http.NewRequest("POST", srv.URL+"/a", io.NopCloser(strings.NewReader("body")))
+	require.Nil(t, err)
+	request.Trailer = http.Header{"X-Checksum": {"abc"}}
+	resp, err := client.Do(request)
+	require.Nil(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Nil(t, err)
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "123", string(body))
+
+	caps := loadCaptures(t, e)
+	require.Len(t, caps, 1)
+	assert.Equal(t, "HTTP/2.0", caps[0].Protocol)
+	assert.Equal(t, "h2", caps[0].TLS.NegotiatedProtocol)
+	assert.Equal(t, srv.Listener.Addr().String(), caps[0].Authority)
+	assert.Equal(t, []byte("body"), caps[0].Body)
+	assert.Equal(t, map[string][]string{"X-Checksum": {"abc"}}, caps[0].Trailers)
+}
+
+func TestEngine_ServeHTTP1Only(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	e := newTestEngine(t, &config.Config{
+		TLS: config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile, HTTP1Only: true},
+	})
+	srv, client := startTLSTestServer(t, e)
+
+	resp, err := client.Get(srv.URL + "/a")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "HTTP/1.1", resp.Proto)
+
+	caps := loadCaptures(t, e)
+	require.Len(t, caps, 1)
+	assert.Equal(t, "HTTP/1.1", caps[0].Protocol)
+	assert.Equal(t, "http/1.1", caps[0].TLS.NegotiatedProtocol)
+	assert.Equal(t, "", caps[0].Authority)
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2023-2024, Open Communications Security
+// This file is part of dummy-http-server and is licensed under the terms of the
+// BSD 3-Clause License.
+"use strict";
+
+// The UI is served from <prefix>/ui/, thus the API is at the parent.
+const API = "../captures";
+const REFRESH_MS = 2000;
+
+let selected = null;
+let filter = new URLSearchParams();
+
+// Decodes a base64 body. Returns the text if it is valid UTF-8, otherwise the
+// hex dump.
+function decodeBody(b64) {
+  if (!b64) {
+    return "";
+  }
+  const bin = atob(b64);
+  const bytes = Uint8Array.from(bin, (c) => c.charCodeAt(0));
+  try {
+    const text = new TextDecoder("utf-8", { fatal: true }).decode(bytes);
+    try {
+      return JSON.stringify(JSON.parse(text), null, 2);
+    } catch (e) {
+      return text;
+    }
+  } catch (e) {
+    return Array.from(bytes, (b) => b.toString(16).padStart(2, "0")).join(" ");
+  }
+}
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  Object.assign(e, attrs || {});
+  for (const c of children) {
+    e.append(c);
+  }
+  return e;
+}
+
+function table(rows) {
+  const t = el("table");
+  for (const [k, v] of rows) {
+    t.append(el("tr", {}, el("th", {}, k), el("td", {}, String(v))));
+  }
+  return t;
+}
+
+function headerRows(headers) {
+  const rows = [];
+  for (const k of Object.keys(headers || {}).sort()) {
+    for (const v of headers[k]) {
+      rows.push([k, v]);
+    }
+  }
+  return rows;
+}
+
+// Quotes a string for POSIX shells.
+function shellQuote(s) {
+  return "'" + s.replace(/'/g, "'\\''") + "'";
+}
+
+// Returns the body as text if it is valid UTF-8 without NUL bytes, that is, if
+// it can be passed as a shell argument. Otherwise, returns null.
+function shellText(bin) {
+  if (bin.includes("\0")) {
+    return null;
+  }
+  try {
+    const bytes = Uint8Array.from(bin, (c) => c.charCodeAt(0));
+    return new TextDecoder("utf-8", { fatal: true }).decode(bytes);
+  } catch (e) {
+    return null;
+  }
+}
+
+function toCurl(c) {
+  // Without the encoded body, the decoded one is sent as is
+  const decoded = !!c.body && !c.encodedBody;
+  const parts = ["curl", "-X", shellQuote(c.Method)];
+  for (const [k, v] of headerRows(c.headers)) {
+    const name = k.toLowerCase();
+    // curl computes the length of the body it sends
+    if (name === "content-length" || (decoded && name === "content-encoding")) {
+      continue;
+    }
+    parts.push("-H", shellQuote(k + ": " + v));
+  }
+  let input = "";
+  const body = c.encodedBody || c.body;
+  if (body) {
+    const text = shellText(atob(body));
+    if (text !== null) {
+      parts.push("--data-binary", shellQuote(text));
+    } else {
+      // Arbitrary bytes cannot be shell arguments
+      input = "echo " + shellQuote(body) + " | base64 -d | ";
+      parts.push("--data-binary", "@-");
+    }
+  }
+  let url = c.url;
+  if (url.startsWith("/")) {
+    url = (c.tls ? "https://" : "http://") + c.host + url;
+  }
+  parts.push(shellQuote(url));
+  return input + parts.join(" ");
+}
+
+function showDetails(c) {
+  const d = document.getElementById("details");
+  d.replaceChildren();
+  if (!c) {
+    d.append(el("p", { className: "empty" }, "Select a request."));
+    return;
+  }
+  const copy = el("button", { textContent: "Copy as curl" });
+  copy.onclick = () => navigator.clipboard.writeText(toCurl(c));
+  const del = el("button", { textContent: "Delete", className: "danger" });
+  del.onclick = async () => {
+    await fetch(API + "/" + encodeURIComponent(c.id), { method: "DELETE" });
+    selected = null;
+    showDetails(null);
+    refresh();
+  };
+  d.append(el("h2", {}, c.Method + " " + c.url));
+  d.append(el("div", { className: "actions" }, copy, del));
+  const info = [
+    ["ID", c.id],
+    ["Timestamp", c.timestamp],
+    ["Host", c.host || ""],
+    ["Remote", c.remote || ""],
+    ["Local", c.local || ""],
+    ["Protocol", c.protocol || ""],
+    ["Rule", c.ruleId || ""],
+    ["Content-Length", c.contentLength],
+    ["Body size", c.bodySize],
+  ];
+  if (c.tls) {
+    info.push(["TLS", c.tls.version + " " + c.tls.cipherSuite + " " + (c.tls.serverName || "")]);
+  }
+  if (c.truncated) {
+    info.push(["Truncated", "yes"]);
+  }
+  d.append(el("h3", {}, "Request"), table(info));
+  d.append(el("h3", {}, "Headers"), table(headerRows(c.headers)));
+  if (c.form) {
+    d.append(el("h3", {}, "Form"), table(headerRows(c.form)));
+  }
+  if (c.files) {
+    d.append(el("h3", {}, "Files"),
+      table(c.files.map((f) => [f.field, `${f.fileName} (${f.contentType || "?"}, ${f.size} bytes) sha256:${f.sha256}`])));
+  }
+  d.append(el("h3", {}, "Body"), el("pre", {}, decodeBody(c.body)));
+}
+
+function renderList(list) {
+  const ul = document.getElementById("list");
+  ul.replaceChildren();
+  // Newest first
+  for (const c of list.slice().reverse()) {
+    const li = el("li", {},
+      el("span", { className: "time" }, new Date(c.timestamp).toLocaleTimeString()),
+      el("span", { className: "method" }, c.Method),
+      c.url);
+    if (selected && selected.id === c.id) {
+      li.className = "selected";
+    }
+    li.onclick = () => {
+      selected = c;
+      showDetails(c);
+      renderList(list);
+    };
+    ul.append(li);
+  }
+}
+
+async function refresh() {
+  try {
+    const resp = await fetch(API + "?" + filter.toString());
+    if (resp.ok) {
+      renderList(await resp.json());
+    }
+  } catch (e) {
+    console.log(e);
+  }
+}
+
+document.getElementById("filter").onsubmit = (ev) => {
+  ev.preventDefault();
+  filter = new URLSearchParams();
+  for (const [k, v] of new FormData(ev.target)) {
+    if (v) {
+      filter.set(k, v);
+    }
+  }
+  refresh();
+};
+
+document.getElementById("filter").onreset = () => {
+  filter = new URLSearchParams();
+  setTimeout(refresh);
+};
+
+document.getElementById("deleteAll").onclick = async () => {
+  if (confirm("Delete all captured requests?")) {
+    await fetch(API, { method: "DELETE" });
+    selected = null;
+    showDetails(null);
+    refresh();
+  }
+};
+
+setInterval(() => {
+  if (document.getElementById("live").checked) {
+    refresh();
+  }
+}, REFRESH_MS);
+refresh();
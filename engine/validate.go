@@ -0,0 +1,331 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+// Problem found in a setting of the configuration.
+type ValidationError struct {
+	// Path of the setting, like "servers[0].responses[1].pathPattern".
+	Path string
+	// Line of the setting in the configuration file or 0 if unknown.
+	Line int
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %v", e.Line, e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Collects the problems of a configuration.
+type validator struct {
+	errs []*ValidationError
+}
+
+func (v *validator) add(path string, err error) {
+	if err != nil {
+		v.errs = append(v.errs, &ValidationError{Path: path, Err: err})
+	}
+}
+
+// Joins the prefix and the name of a setting.
+func settingPath(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func (v *validator) address(path string, address string) {
+	if socket, ok := UnixSocketPath(address); ok {
+		if socket == "" {
+			v.add(path, errors.New("the socket path is required"))
+		}
+		return
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		v.add(path, err)
+	}
+}
+
+func (v *validator) writableDir(path string, dir string) {
+	if dir == "" {
+		dir = "."
+	}
+	v.add(path, checkWritable(dir))
+}
+
+func (v *validator) urlPath(path string, value string) {
+	if !strings.HasPrefix(value, "/") {
+		v.add(path, fmt.Errorf("'%s' must start with '/'", value))
+	}
+}
+
+func (v *validator) tls(prefix string, cfg *config.Config) {
+	t := &cfg.TLS
+	if !t.Enabled {
+		return
+	}
+	_, err := ParseTLSVersion(t.MinVersion)
+	v.add(settingPath(prefix, "tls.minVersion"), err)
+	for i, name := range t.CipherSuites {
+		_, err := ParseCipherSuite(name)
+		v.add(fmt.Sprintf("%s[%d]", settingPath(prefix, "tls.cipherSuites"), i), err)
+	}
+	clientAuth, err := ParseClientAuth(t.ClientAuth)
+	v.add(settingPath(prefix, "tls.clientAuth"), err)
+	if t.ClientCAFile != "" {
+		_, err := loadCertPool(t.ClientCAFile)
+		v.add(settingPath(prefix, "tls.clientCAFile"), err)
+	} else if err == nil && clientAuth == tls.RequireAndVerifyClientCert {
+		v.add(settingPath(prefix, "tls.clientCAFile"),
+			errors.New("the client CA file is required to verify the client certificates"))
+	}
+	if t.Auto {
+		// The default directory is the capture directory
+		if t.AutoDir != "" {
+			v.writableDir(settingPath(prefix, "tls.autoDir"), t.AutoDir)
+		}
+	} else if t.CertFile == "" || t.KeyFile == "" {
+		v.add(settingPath(prefix, "tls"), errors.New("the TLS certificate and key files are required"))
+	} else {
+		_, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		v.add(settingPath(prefix, "tls.certFile"), err)
+	}
+}
+
+/*
+Checks if the method is a token as defined by RFC 9110, thus extension methods
+like PROPFIND are accepted.
+*/
+func isMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for _, c := range []byte(method) {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks what NewResponseFromConfig() does not.
+func (v *validator) response(prefix string, r *config.ResponseConfig) {
+	_, err := NewResponseFromConfig(r)
+	v.add(prefix, err)
+	for i, method := range r.Methods {
+		if !isMethod(method) {
+			v.add(fmt.Sprintf("%s[%d]", settingPath(prefix, "methods"), i), fmt.Errorf("invalid method '%s'", method))
+		}
+	}
+	if r.ReturnCode != 0 && (r.ReturnCode < 100 || r.ReturnCode > 599) {
+		v.add(settingPath(prefix, "returnCode"), fmt.Errorf("invalid status code %d", r.ReturnCode))
+	}
+}
+
+func (v *validator) responses(prefix string, responses []*config.ResponseConfig, defaultID func(int) string) {
+	ids := make(map[string]bool)
+	for i, r := range responses {
+		path := fmt.Sprintf("%s[%d]", settingPath(prefix, "responses"), i)
+		if r == nil {
+			continue
+		}
+		v.response(path, r)
+		id := r.ID
+		if id == "" {
+			id = defaultID(i)
+		}
+		if ids[id] {
+			v.add(settingPath(path, "id"), fmt.Errorf("duplicated id '%s'", id))
+		}
+		ids[id] = true
+	}
+}
+
+func (v *validator) virtualHosts(prefix string, vhosts []*config.VirtualHostConfig) {
+	names := make(map[string]bool)
+	for i, vhost := range vhosts {
+		path := fmt.Sprintf("%s[%d]", settingPath(prefix, "virtualHosts"), i)
+		if vhost == nil {
+			continue
+		}
+		name := vhost.Name
+		if name == "" {
+			name = DefaultVirtualHostName(i)
+		}
+		if names[name] {
+			v.add(settingPath(path, "name"), fmt.Errorf("duplicated name '%s'", name))
+		}
+		names[name] = true
+		if len(vhost.Hosts) == 0 {
+			v.add(settingPath(path, "hosts"), errors.New("at least one host is required"))
+		}
+		v.responses(path, vhost.Responses, func(index int) string {
+			return name + "-" + DefaultResponseID(index)
+		})
+	}
+}
+
+// Validates the settings that may differ between servers.
+func (v *validator) server(prefix string, cfg *config.Config, captureDir bool) {
+	v.address(settingPath(prefix, "address"), cfg.Address)
+	if captureDir {
+		v.writableDir(settingPath(prefix, "captureDir"), cfg.CaptureDir)
+	}
+	if cfg.ReadTimeout < 0 {
+		v.add(settingPath(prefix, "readTimeout"), errors.New("it must not be negative"))
+	}
+	if cfg.WriteTimeout < 0 {
+		v.add(settingPath(prefix, "writeTimeout"), errors.New("it must not be negative"))
+	}
+	v.tls(prefix, cfg)
+	if cfg.Admin.Enabled {
+		v.add(settingPath(prefix, "admin.prefix"), CheckAdminPrefix(cfg.Admin.Prefix))
+		if cfg.Admin.Address != "" {
+			v.address(settingPath(prefix, "admin.address"), cfg.Admin.Address)
+		}
+	}
+	_, err := ParseUnknownHostPolicy(cfg.UnknownHost)
+	v.add(settingPath(prefix, "unknownHost"), err)
+	v.responses(prefix, cfg.Responses, DefaultResponseID)
+	v.virtualHosts(prefix, cfg.VirtualHosts)
+}
+
+/*
+Validates all settings of the configuration and returns every problem found.
+The directories must exist and be writable.
+*/
+func ValidateConfig(cfg *config.Config) []*ValidationError {
+	v := &validator{}
+	v.server("", cfg, true)
+	_, err := ParseSocketMode(cfg.SocketMode)
+	v.add("socketMode", err)
+	if cfg.ShutdownTimeout < 0 {
+		v.add("shutdownTimeout", errors.New("it must not be negative"))
+	}
+	if cfg.MaxRequestSize <= 0 {
+		v.add("maxRequestSize", errors.New("it must be positive"))
+	}
+	_, err = ParseOverflowPolicy(cfg.BodyOverflow)
+	v.add("bodyOverflow", err)
+
+	// Logging
+	_, err = ParseLogLevel(cfg.Logging.Level)
+	v.add("logging.level", err)
+	_, err = newLogEncoder(cfg.Logging.Format)
+	v.add("logging.format", err)
+	outputs := cfg.Logging.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{LOG_OUTPUT_FILE}
+	}
+	for i, output := range outputs {
+		switch strings.ToLower(output) {
+		case LOG_OUTPUT_FILE:
+			// The default log file is inside the capture directory
+			if cfg.Logging.File != "" {
+				v.writableDir("logging.file", filepath.Dir(cfg.Logging.File))
+			}
+		case LOG_OUTPUT_STDOUT, LOG_OUTPUT_STDERR:
+		default:
+			v.add(fmt.Sprintf("logging.outputs[%d]", i), fmt.Errorf("invalid log output '%s'", output))
+		}
+	}
+	if cfg.AccessLog.Enabled {
+		_, err = NewAccessLog(cfg.AccessLog.Format, nil)
+		v.add("accessLog.format", err)
+		if cfg.AccessLog.File != "" {
+			v.writableDir("accessLog.file", filepath.Dir(cfg.AccessLog.File))
+		}
+	}
+	if cfg.Health.Enabled {
+		v.urlPath("health.livePath", cfg.Health.LivePath)
+		v.urlPath("health.readyPath", cfg.Health.ReadyPath)
+	}
+
+	// Servers
+	configs, err := cfg.ServerConfigs()
+	if err != nil {
+		v.add("servers", err)
+		return v.errs
+	}
+	for i, server := range configs[1:] {
+		v.server(fmt.Sprintf("servers[%d]", i), server, cfg.Servers[i].CaptureDir != "")
+	}
+	return v.errs
+}
+
+/*
+Loads and validates the configuration file. Besides the problems reported by
+ValidateConfig(), YAML and JSON files are also checked for unknown settings and
+the problems receive the line of the setting. The problems are sorted by line.
+The error is returned only if the file cannot be loaded.
+*/
+func ValidateConfigFile(file string) ([]*ValidationError, error) {
+	// TOML and other formats are only validated by ValidateConfig()
+	cfg, err := config.LoadConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	errs := ValidateConfig(cfg)
+	switch strings.ToLower(path.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		source, err := config.LoadSourceMap(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range source.UnknownKeys() {
+			errs = append(errs, &ValidationError{Path: key, Err: errors.New("unknown setting")})
+		}
+		for _, e := range errs {
+			e.Line = source.Line(e.Path)
+		}
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Line < errs[j].Line
+		})
+	}
+	return errs, nil
+}
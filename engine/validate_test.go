@@ -0,0 +1,225 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+// Returns the paths of the problems.
+func problemPaths(problems []*ValidationError) []string {
+	var ret []string
+	for _, p := range problems {
+		ret = append(ret, p.Path)
+	}
+	return ret
+}
+
+func newValidConfig(t *testing.T) *config.Config {
+	return &config.Config{
+		Address:        ":8080",
+		CaptureDir:     t.TempDir(),
+		MaxRequestSize: 1024,
+		UnknownHost:    "responses",
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := &ValidationError{Path: "a.b", Err: assert.AnError}
+	assert.Equal(t, "a.b: "+assert.AnError.Error(), err.Error())
+	err.Line = 3
+	assert.Equal(t, "line 3: a.b: "+assert.AnError.Error(), err.Error())
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestValidateConfig(t *testing.T) {
+	cfg := newValidConfig(t)
+	cfg.Responses = []*config.ResponseConfig{
+		{PathPattern: "^/a$", Methods: []string{"GET", "POST", "PROPFIND", "get"}, Body: "MTIz", ReturnCode: 201},
+	}
+	assert.Nil(t, ValidateConfig(cfg))
+
+	cfg.Address = "8080"
+	cfg.MaxRequestSize = 0
+	cfg.SocketMode = "999"
+	cfg.BodyOverflow = "drop"
+	cfg.Logging = config.LoggingConfig{Level: "loud", Format: "xml", Outputs: []string{"syslog"}}
+	cfg.AccessLog = config.AccessLogConfig{Enabled: true, Format: "{{"}
+	cfg.Health = config.HealthConfig{Enabled: true, LivePath: "healthz", ReadyPath: "/readyz"}
+	cfg.Admin = config.AdminConfig{Enabled: true, Prefix: "admin"}
+	cfg.UnknownHost = "ignore"
+	cfg.Responses = []*config.ResponseConfig{
+		{PathPattern: "[", Methods: []string{"GET", "G T", ""}, ReturnCode: 42},
+		{ID: "response-0", Body: "%"},
+		{BodyOverflow: "x"},
+		{ClientCertSubject: "("},
+		{ClientCertFingerprint: "zz"},
+	}
+	cfg.VirtualHosts = []*config.VirtualHostConfig{
+		{Name: "a", Hosts: []string{"a"}, Responses: []*config.ResponseConfig{{ReturnCode: 600}}},
+		{Name: "a"},
+	}
+	assert.Equal(t, []string{
+		"address",
+		"admin.prefix",
+		"unknownHost",
+		"responses[0]",
+		"responses[0].methods[1]",
+		"responses[0].methods[2]",
+		"responses[0].returnCode",
+		"responses[1]",
+		"responses[1].id",
+		"responses[2]",
+		"responses[3]",
+		"responses[4]",
+		"virtualHosts[0].responses[0].returnCode",
+		"virtualHosts[1].name",
+		"virtualHosts[1].hosts",
+		"socketMode",
+		"maxRequestSize",
+		"bodyOverflow",
+		"logging.level",
+		"logging.format",
+		"logging.outputs[0]",
+		"accessLog.format",
+		"health.livePath",
+	}, problemPaths(ValidateConfig(cfg)))
+}
+
+func TestValidateConfig_AccessLogFields(t *testing.T) {
+	cfg := newValidConfig(t)
+	cfg.AccessLog = config.AccessLogConfig{Enabled: true, Format: "{{.Nope}}"}
+	assert.Equal(t, []string{"accessLog.format"}, problemPaths(ValidateConfig(cfg)))
+}
+
+func TestValidateConfig_Dirs(t *testing.T) {
+	cfg := newValidConfig(t)
+	missing := path.Join(cfg.CaptureDir, "missing")
+	cfg.Logging = config.LoggingConfig{Outputs: []string{"file", "stdout"}, File: path.Join(missing, "log.log")}
+	cfg.AccessLog = config.AccessLogConfig{Enabled: true, File: path.Join(missing, "access.log")}
+	cfg.TLS = config.TLSConfig{Enabled: true, Auto: true, AutoDir: missing}
+	assert.Equal(t, []string{"tls.autoDir", "logging.file", "accessLog.file"}, problemPaths(ValidateConfig(cfg)))
+
+	cfg = newValidConfig(t)
+	cfg.CaptureDir = missing
+	assert.Equal(t, []string{"captureDir"}, problemPaths(ValidateConfig(cfg)))
+}
+
+func TestValidateConfig_TLS(t *testing.T) {
+	cfg := newValidConfig(t)
+	cfg.TLS = config.TLSConfig{Enabled: true, MinVersion: "1.2"}
+	assert.Equal(t, []string{"tls"}, problemPaths(ValidateConfig(cfg)))
+
+	certFile, keyFile := writeTestCertificate(t, cfg.CaptureDir)
+	cfg.TLS = config.TLSConfig{Enabled: true, MinVersion: "1.2", CertFile: certFile, KeyFile: keyFile}
+	assert.Nil(t, ValidateConfig(cfg))
+
+	cfg.TLS.MinVersion = "2.0"
+	cfg.TLS.CipherSuites = []string{"TLS_AES_128_GCM_SHA256", "BAD"}
+	cfg.TLS.ClientAuth = "require"
+	cfg.TLS.KeyFile = certFile
+	assert.Equal(t, []string{"tls.minVersion", "tls.cipherSuites[1]", "tls.clientCAFile", "tls.certFile"},
+		problemPaths(ValidateConfig(cfg)))
+}
+
+func TestValidateConfig_Servers(t *testing.T) {
+	cfg := newValidConfig(t)
+	cfg.Servers = []*config.ServerConfig{
+		{Name: "s1", Address: ":8081", Responses: []*config.ResponseConfig{{PathPattern: "["}}},
+		{Name: "s2", Address: ":8082", CaptureDir: path.Join(cfg.CaptureDir, "missing"), UnknownHost: "x"},
+	}
+	assert.Equal(t, []string{
+		"servers[0].responses[0]",
+		"servers[1].captureDir",
+		"servers[1].unknownHost",
+	}, problemPaths(ValidateConfig(cfg)))
+
+	cfg.Servers = append(cfg.Servers, &config.ServerConfig{Name: "s1", Address: ":8083"})
+	assert.Equal(t, []string{"servers"}, problemPaths(ValidateConfig(cfg)))
+}
+
+func TestValidateConfig_AdminPrefix(t *testing.T) {
+	cfg := newValidConfig(t)
+	cfg.Admin = config.AdminConfig{Enabled: true, Prefix: "/"}
+	cfg.Servers = []*config.ServerConfig{
+		{Name: "s1", Address: ":8081", Admin: config.AdminConfig{Enabled: true, Prefix: "//"}},
+	}
+	assert.Equal(t, []string{"admin.prefix", "servers[0].admin.prefix"}, problemPaths(ValidateConfig(cfg)))
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	root := t.TempDir()
+	file := path.Join(root, "config.yaml")
+	require.Nil(t, os.WriteFile(file, []byte(`captureDir: `+root+`
+responses:
+  - pathPattern: "["
+    returnCode: 200
+  - methods: [GET, "G T"]
+adress: ":8080"
+`), 0644))
+	problems, err := ValidateConfigFile(file)
+	require.Nil(t, err)
+	require.Len(t, problems, 3)
+	assert.Equal(t, "line 3: responses[0]: error parsing regexp: missing closing ]: `[`", problems[0].Error())
+	assert.Equal(t, "line 5: responses[1].methods[1]: invalid method 'G T'", problems[1].Error())
+	assert.Equal(t, "line 6: adress: unknown setting", problems[2].Error())
+
+	require.Nil(t, os.WriteFile(file, []byte("captureDir: "+root+"\n"), 0644))
+	problems, err = ValidateConfigFile(file)
+	assert.Nil(t, err)
+	assert.Nil(t, problems)
+
+	_, err = ValidateConfigFile(path.Join(root, "missing.yaml"))
+	assert.NotNil(t, err)
+}
+
+func TestStartServer_Strict(t *testing.T) {
+	root := t.TempDir()
+	file := path.Join(root, "config.yaml")
+	require.Nil(t, os.WriteFile(file, []byte("strict: true\ncaptureDir: "+root+
+		"\nresponses:\n  - body: \"%\"\n"), 0644))
+	err := StartServer(file)
+	assert.ErrorContains(t, err, "the configuration has 1 problem(s) and strict is set")
+	assert.ErrorContains(t, err, "line 4: responses[0]: illegal base64")
+}
+
+func TestValidateConfigFile_Presets(t *testing.T) {
+	root := t.TempDir()
+	for _, preset := range config.Presets() {
+		file := path.Join(root, preset+".yaml")
+		require.Nil(t, config.Scaffold(file, &config.ScaffoldOptions{Preset: preset, CaptureDir: path.Join(root, "var")}))
+		problems, err := ValidateConfigFile(file)
+		require.Nil(t, err)
+		assert.Nil(t, problems, preset)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+// Policy applied to requests that match no virtual host.
+type UnknownHostPolicy string
+
+const (
+	// Uses the responses of the server.
+	UNKNOWN_HOST_RESPONSES UnknownHostPolicy = "responses"
+	// Uses the default response.
+	UNKNOWN_HOST_DEFAULT UnknownHostPolicy = "default"
+	// Replies with MISDIRECTED_RESPONSE.
+	UNKNOWN_HOST_REJECT UnknownHostPolicy = "reject"
+)
+
+var (
+	// The identifier of the misdirected response.
+	MISDIRECTED_RESPONSE_ID string = "misdirected"
+	// The response used to reject requests to unknown hosts. It replies with
+	// 421 Misdirected Request.
+	MISDIRECTED_RESPONSE = (&ResponseBuilder{}).SetID(MISDIRECTED_RESPONSE_ID).
+				SetResponseCode(http.StatusMisdirectedRequest).SetContentType("text/plain").
+				SetBody([]byte(http.StatusText(http.StatusMisdirectedRequest))).Build()
+)
+
+// Parses an unknown host policy. The empty string is parsed as
+// UNKNOWN_HOST_RESPONSES.
+func ParseUnknownHostPolicy(s string) (UnknownHostPolicy, error) {
+	switch p := UnknownHostPolicy(strings.ToLower(s)); p {
+	case "":
+		return UNKNOWN_HOST_RESPONSES, nil
+	case UNKNOWN_HOST_RESPONSES, UNKNOWN_HOST_DEFAULT, UNKNOWN_HOST_REJECT:
+		return p, nil
+	default:
+		return UNKNOWN_HOST_RESPONSES, fmt.Errorf("invalid unknown host policy '%s'", s)
+	}
+}
+
+/*
+Checks if the host name matches the pattern. The comparison is case-insensitive
+and "*" matches exactly one label, thus "*.example.com" matches "a.example.com"
+but neither "example.com" nor "a.b.example.com". The pattern "*" alone matches
+any host.
+*/
+func MatchHost(pattern string, host string) bool {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(host)
+	if pattern == "*" {
+		return true
+	}
+	patternLabels := strings.Split(pattern, ".")
+	hostLabels := strings.Split(host, ".")
+	if len(patternLabels) != len(hostLabels) {
+		return false
+	}
+	for i, p := range patternLabels {
+		if p == "*" {
+			if hostLabels[i] == "" {
+				return false
+			}
+		} else if p != hostLabels[i] {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Returns the host name used to select the virtual host: the Host header without
+the port or, if it is not set, the server name sent by the client using SNI.
+*/
+func RequestHost(request *http.Request) string {
+	host := request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if host == "" && request.TLS != nil {
+		host = request.TLS.ServerName
+	}
+	return strings.ToLower(host)
+}
+
+// A group of responses selected by the host name of the request.
+type VirtualHost struct {
+	Name      string
+	Hosts     []string
+	Responses ResponseSet
+}
+
+// Checks if the host name matches any host of this virtual host.
+func (v *VirtualHost) Match(host string) bool {
+	for _, pattern := range v.Hosts {
+		if MatchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the name of the virtual host at the given index of the configuration
+// when it does not define one.
+func DefaultVirtualHostName(index int) string {
+	return fmt.Sprintf("vhost-%d", index)
+}
+
+/*
+Creates the virtual hosts defined by the configuration. Responses without ID
+receive "<name>-response-<index>". Invalid responses are skipped and reported in
+the returned error; groups without hosts or with a duplicated name are skipped.
+*/
+func NewVirtualHostsFromConfig(configs []*config.VirtualHostConfig) ([]*VirtualHost, error) {
+	var vhosts []*VirtualHost
+	var errs []error
+	names := make(map[string]bool)
+	for i, cfg := range configs {
+		name := cfg.Name
+		if name == "" {
+			name = DefaultVirtualHostName(i)
+		}
+		if names[name] {
+			errs = append(errs, fmt.Errorf("virtual host %d: duplicated name '%s'", i, name))
+			continue
+		}
+		names[name] = true
+		if len(cfg.Hosts) == 0 {
+			errs = append(errs, fmt.Errorf("virtual host %d: at least one host is required", i))
+			continue
+		}
+		responses, responseErrs := newResponsesFromConfig(cfg.Responses, func(index int) string {
+			return name + "-" + DefaultResponseID(index)
+		})
+		for _, err := range responseErrs {
+			errs = append(errs, fmt.Errorf("virtual host %d: %w", i, err))
+		}
+		vhost := &VirtualHost{Name: name, Hosts: cfg.Hosts}
+		vhost.Responses.SetResponses(responses)
+		vhosts = append(vhosts, vhost)
+	}
+	return vhosts, errors.Join(errs...)
+}
+
+// Returns the first virtual host that matches the host name or nil.
+func FindVirtualHost(vhosts []*VirtualHost, host string) *VirtualHost {
+	for _, v := range vhosts {
+		if v.Match(host) {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2023-2024, Open Communications Security
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package engine
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.opencs.dev.br/opencs-commons/dummy-http-server/config"
+)
+
+func TestParseUnknownHostPolicy(t *testing.T) {
+	for s, exp := range map[string]UnknownHostPolicy{
+		"":          UNKNOWN_HOST_RESPONSES,
+		"responses": UNKNOWN_HOST_RESPONSES,
+		"Default":   UNKNOWN_HOST_DEFAULT,
+		"reject":    UNKNOWN_HOST_REJECT,
+	} {
+		p, err := ParseUnknownHostPolicy(s)
+		assert.Nil(t, err)
+		assert.Equal(t, exp, p)
+	}
+	_, err := ParseUnknownHostPolicy("x")
+	assert.ErrorContains(t, err, "invalid unknown host policy 'x'")
+}
+
+func TestMatchHost(t *testing.T) {
+	assert.True(t, MatchHost("api.local", "api.local"))
+	assert.True(t, MatchHost("API.local", "api.LOCAL"))
+	assert.False(t, MatchHost("api.local", "auth.local"))
+	assert.True(t, MatchHost("*.api.local", "v1.api.local"))
+	assert.False(t, MatchHost("*.api.local", "api.local"))
+	assert.False(t, MatchHost("*.api.local", "a.v1.api.local"))
+	assert.False(t, MatchHost("*.api.local", ".api.local"))
+	assert.True(t, MatchHost("api.*", "api.local"))
+	assert.True(t, MatchHost("*", "anything.local"))
+	assert.True(t, MatchHost("*", ""))
+}
+
+func TestRequestHost(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "API.local:8080"
+	assert.Equal(t, "api.local", RequestHost(r))
+	r.Host = "api.local."
+	assert.Equal(t, "api.local", RequestHost(r))
+	r.Host = "[::1]:8080"
+	assert.Equal(t, "::1", RequestHost(r))
+	r.Host = ""
+	assert.Equal(t, "", RequestHost(r))
+	r.TLS = &tls.ConnectionState{ServerName: "auth.local"}
+	assert.Equal(t, "auth.local", RequestHost(r))
+	r.Host = "api.local"
+	assert.Equal(t, "api.local", RequestHost(r))
+}
+
+func TestNewVirtualHostsFromConfig(t *testing.T) {
+	vhosts, err := NewVirtualHostsFromConfig([]*config.VirtualHostConfig{
+		{Name: "api", Hosts: []string{"api.local", "*.api.local"},
+			Responses: []*config.ResponseConfig{{ReturnCode: 201}, {ID: "r1"}}},
+		{Hosts: []string{"auth.local"}},
+	})
+	require.Nil(t, err)
+	require.Len(t, vhosts, 2)
+	assert.Equal(t, "api", vhosts[0].Name)
+	responses := vhosts[0].Responses.Responses()
+	require.Len(t, responses, 2)
+	assert.Equal(t, "api-response-0", responses[0].ID())
+	assert.Equal(t, "r1", responses[1].ID())
+	assert.Equal(t, "vhost-1", vhosts[1].Name)
+	assert.Empty(t, vhosts[1].Responses.Responses())
+
+	assert.Equal(t, vhosts[0], FindVirtualHost(vhosts, "v1.api.local"))
+	assert.Equal(t, vhosts[1], FindVirtualHost(vhosts, "auth.local"))
+	assert.Nil(t, FindVirtualHost(vhosts, "other.local"))
+
+	vhosts, err = NewVirtualHostsFromConfig([]*config.VirtualHostConfig{
+		{Name: "a", Hosts: []string{"a"}, Responses: []*config.ResponseConfig{{PathPattern: "["}, {}}},
+		{Name: "a", Hosts: []string{"b"}},
+		{Name: "c"},
+	})
+	assert.ErrorContains(t, err, "virtual host 0: response 0:")
+	assert.ErrorContains(t, err, "virtual host 1: duplicated name 'a'")
+	assert.ErrorContains(t, err, "virtual host 2: at least one host is required")
+	require.Len(t, vhosts, 1)
+	assert.Len(t, vhosts[0].Responses.Responses(), 1)
+}
+
+func TestEngine_ServeHTTPVirtualHosts(t *testing.T) {
+	newEngine := func(unknownHost string) *Engine {
+		return newTestEngine(t, &config.Config{
+			UnknownHost: unknownHost,
+			Responses:   []*config.ResponseConfig{{ReturnCode: 202}},
+			VirtualHosts: []*config.VirtualHostConfig{
+				{Name: "api", Hosts: []string{"api.local"},
+					Responses: []*config.ResponseConfig{{PathPattern: "^/a$", ReturnCode: 201}}},
+				{Name: "auth", Hosts: []string{"*.auth.local"},
+					Responses: []*config.ResponseConfig{{ReturnCode: 203}}},
+			},
+		})
+	}
+	serveHost := func(e *Engine, host string, target string) int {
+		r := httptest.NewRequest("GET", target, nil)
+		r.Host = host
+		resp := httptest.NewRecorder()
+		e.ServeHTTP(resp, r)
+		return resp.Code
+	}
+
+	e := newEngine("")
+	assert.Equal(t, 201, serveHost(e, "api.local:8080", "/a"))
+	assert.Equal(t, 200, serveHost(e, "api.local", "/b"))
+	assert.Equal(t, 203, serveHost(e, "v1.auth.local", "/a"))
+	assert.Equal(t, 202, serveHost(e, "other.local", "/a"))
+	caps := loadCaptures(t, e)
+	require.Len(t, caps, 4)
+	vhosts := map[string]int{}
+	for _, c := range caps {
+		vhosts[c.VirtualHost]++
+	}
+	assert.Equal(t, map[string]int{"api": 2, "auth": 1, "": 1}, vhosts)
+
+	e = newEngine("default")
+	assert.Equal(t, 201, serveHost(e, "api.local", "/a"))
+	assert.Equal(t, 200, serveHost(e, "other.local", "/a"))
+
+	e = newEngine("reject")
+	assert.Equal(t, 201, serveHost(e, "api.local", "/a"))
+	assert.Equal(t, 421, serveHost(e, "other.local", "/a"))
+	caps = loadCaptures(t, e)
+	require.Len(t, caps, 2)
+
+	_, err := NewEngine(&config.Config{CaptureDir: t.TempDir(), UnknownHost: "x"})
+	assert.ErrorContains(t, err, "invalid unknown host policy 'x'")
+}